@@ -0,0 +1,34 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of ClusterEstIssuers,
+// which share the spec and thus the webhooks of EstIssuers.
+func (r *ClusterEstIssuer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&issuerDefaulter{}).
+		WithValidator(&issuerValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-certmanager-jquad-rocks-v1-clusterestissuer,mutating=true,failurePolicy=fail,sideEffects=None,groups=certmanager.jquad.rocks,resources=clusterestissuers,verbs=create;update,versions=v1,name=mclusterestissuer.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-certmanager-jquad-rocks-v1-clusterestissuer,mutating=false,failurePolicy=fail,sideEffects=None,groups=certmanager.jquad.rocks,resources=clusterestissuers,verbs=create;update,versions=v1,name=vclusterestissuer.kb.io,admissionReviewVersions=v1
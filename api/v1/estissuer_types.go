@@ -24,30 +24,166 @@ import (
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // EstIssuerSpec defines the desired state of EstIssuer
+// +kubebuilder:validation:XValidation:rule="has(self.authSecretName) || has(self.clientCertSecretName)",message="at least one of authSecretName or clientCertSecretName must be set"
+// +kubebuilder:validation:XValidation:rule="!(has(self.cacert) && has(self.tlsTrustAnchor))",message="cacert and tlsTrustAnchor are mutually exclusive"
+// +kubebuilder:validation:XValidation:rule="has(self.url) != has(self.hostname)",message="exactly one of url or hostname must be set"
 type EstIssuerSpec struct {
-	// DNS name of the portal.
-	// +kubebuilder:validation:Required
-	Hostname string `json:"hostname"`
+	// DNS name of the portal. Either hostname or url must be set.
+	// +kubebuilder:validation:Optional
+	Hostname string `json:"hostname,omitempty"`
 
-	// Port number of the portal
-	// +kubebuilder:validation:Required
-	Port int `json:"port"`
+	// Port number of the portal, the HTTPS port if not set. Only valid along with hostname.
+	// +kubebuilder:validation:Optional
+	Port int `json:"port,omitempty"`
+
+	// The base URL of the portal, e.g. https://est.example.com:8443 or https://gateway.example.com/pki, which the
+	// well-known path is appended to. Replaces hostname and port. Only the https scheme is supported.
+	// +kubebuilder:validation:Optional
+	URL string `json:"url,omitempty"`
 
 	// Interface label as described in RFC 7030 Sec. 3.2.2. Labels are added to the “well-known” path to enable one portal to support multiple issuers.
 	// +kubebuilder:validation:Optional
 	Label string `json:"label,omitempty"`
 
-	// /.well-known/est
+	// The path the EST operations are served under, /.well-known/est if not set. Portals behind gateways which
+	// rewrite paths may serve them under another prefix.
 	// +kubebuilder:validation:Optional
 	WellKnown string `json:"wellKnown,omitempty"`
 
-	// The root certificate the portal issues under. The certificate must be in PEM encoding, and then base64 encoded
+	// Overrides the paths of individual EST operations, which otherwise are served under the well-known path and the label.
+	// +kubebuilder:validation:Optional
+	Paths *EstOperationPaths `json:"paths,omitempty"`
+
+	// The certificates the TLS server certificate of the portal is verified with. The certificates must be in PEM encoding,
+	// and then base64 encoded. Deprecated: use tlsTrustAnchor.
+	// +kubebuilder:validation:Optional
+	Cacert string `json:"cacert,omitempty"`
+
+	// The certificates the TLS server certificate of the portal is verified with, the Explicit TA database of RFC 7030 Sec. 3.6.1.
+	// If neither tlsTrustAnchor nor cacert is set, the system trust store is used as Implicit TA database (RFC 7030 Sec. 3.6.2).
+	// +kubebuilder:validation:Optional
+	TLSTrustAnchor *CertificateSource `json:"tlsTrustAnchor,omitempty"`
+
+	// The CA certificates the portal is expected to issue under. If set, the certificates served by /cacerts and
+	// the issued certificates must chain to one of them.
+	// +kubebuilder:validation:Optional
+	CABundle *CertificateSource `json:"caBundle,omitempty"`
+
+	// The name of a Secret holding the EST Portal credential in its 'username' and 'password' keys. est-operator supports
+	// HTTP Basic Authentication for initial enrollment.
+	// +kubebuilder:validation:Optional
+	AuthSecretName string `json:"authSecretName,omitempty"`
+
+	// Verifies the credentials with an authenticated request to the /csrattrs endpoint of the portal, which does not
+	// issue a certificate, when the issuer is validated. Only effective if the portal requires authentication for /csrattrs.
+	// +kubebuilder:validation:Optional
+	ProbeCredentials bool `json:"probeCredentials,omitempty"`
+
+	// The name of a kubernetes.io/tls Secret holding the bootstrap certificate and key, which are presented to the EST portal
+	// for TLS client authentication as described in RFC 7030 Sec. 3.3.2. Can be combined with AuthSecretName.
+	// +kubebuilder:validation:Optional
+	ClientCertSecretName string `json:"clientCertSecretName,omitempty"`
+
+	// Publishes the CA certificates served by the /cacerts endpoint of the portal to a ConfigMap or Secret,
+	// which is kept up to date as the CA certificates change.
+	// +kubebuilder:validation:Optional
+	CACertsTarget *CACertsTarget `json:"caCertsTarget,omitempty"`
+
+	// Submits enrollments to the /fullcmc endpoint as Full CMC requests (RFC 7030 Sec. 4.3) instead of /simpleenroll.
+	// +kubebuilder:validation:Optional
+	FullCMC *FullCMC `json:"fullCMC,omitempty"`
+
+	// The interval at which the issuer is revalidated and its CA certificates are fetched again. Failed validations
+	// are retried with an exponential backoff up to this interval. Defaults to 1h.
+	// +kubebuilder:validation:Optional
+	HealthCheckInterval *metav1.Duration `json:"healthCheckInterval,omitempty"`
+
+	// The maximum time an enrollment deferred by the EST portal with 202 Accepted, e.g. for manual approval, is polled before the EstOrder fails. Defaults to 24h.
+	// +kubebuilder:validation:Optional
+	PendingTimeout *metav1.Duration `json:"pendingTimeout,omitempty"`
+}
+
+// EstOperationPaths are the paths of the EST operations of a portal. Each path replaces the well-known path,
+// the label and the name of the operation, and is appended to the path of the url, if any.
+type EstOperationPaths struct {
+	// The path of the /cacerts operation.
+	// +kubebuilder:validation:Optional
+	CACerts string `json:"cacerts,omitempty"`
+
+	// The path of the /simpleenroll operation.
+	// +kubebuilder:validation:Optional
+	SimpleEnroll string `json:"simpleenroll,omitempty"`
+
+	// The path of the /simplereenroll operation.
+	// +kubebuilder:validation:Optional
+	SimpleReenroll string `json:"simplereenroll,omitempty"`
+
+	// The path of the /fullcmc operation.
+	// +kubebuilder:validation:Optional
+	FullCMC string `json:"fullcmc,omitempty"`
+
+	// The path of the /serverkeygen operation.
+	// +kubebuilder:validation:Optional
+	ServerKeyGen string `json:"serverkeygen,omitempty"`
+
+	// The path of the /csrattrs operation.
+	// +kubebuilder:validation:Optional
+	CSRAttrs string `json:"csrattrs,omitempty"`
+}
+
+// CertificateSource refers to PEM encoded certificates, which are given inline or read from a ConfigMap or Secret.
+// ConfigMaps and Secrets are looked up in the namespace of the issuer's Secrets.
+// +kubebuilder:validation:XValidation:rule="[has(self.inline), has(self.configMapRef), has(self.secretRef)].filter(x, x).size() == 1",message="exactly one of inline, configMapRef or secretRef must be set"
+type CertificateSource struct {
+	// The PEM encoded certificates.
+	// +kubebuilder:validation:Optional
+	Inline string `json:"inline,omitempty"`
+
+	// A key of a ConfigMap holding the PEM encoded certificates.
+	// +kubebuilder:validation:Optional
+	ConfigMapRef *KeySelector `json:"configMapRef,omitempty"`
+
+	// A key of a Secret holding the PEM encoded certificates.
+	// +kubebuilder:validation:Optional
+	SecretRef *KeySelector `json:"secretRef,omitempty"`
+}
+
+// KeySelector selects a key of a ConfigMap or Secret.
+type KeySelector struct {
+	// The name of the ConfigMap or Secret.
 	// +kubebuilder:validation:Required
-	Cacert string `json:"cacert"`
+	Name string `json:"name"`
 
-	// The name of a Secret holding the EST Portal credential. est-operator supports HTTP Basic Authentication for initial enrollment.
+	// The key holding the certificates.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=ca.crt
+	Key string `json:"key,omitempty"`
+}
+
+// CACertsTarget is the ConfigMap or Secret the CA certificates of an issuer are published to. It is created
+// in the namespace of the issuer's Secrets and owned by the issuer.
+type CACertsTarget struct {
+	// The kind of the object, either ConfigMap or Secret.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=ConfigMap;Secret
+	// +kubebuilder:default=ConfigMap
+	Kind string `json:"kind,omitempty"`
+
+	// The name of the ConfigMap or Secret.
 	// +kubebuilder:validation:Required
-	AuthSecretName string `json:"authSecretName"`
+	Name string `json:"name"`
+
+	// The key the PEM encoded CA certificates are stored under.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=ca.crt
+	Key string `json:"key,omitempty"`
+}
+
+// FullCMC configures the Full CMC enrollment of an issuer.
+type FullCMC struct {
+	// The name of a kubernetes.io/tls Secret holding the RA certificate and key the CMC requests are signed with.
+	// +kubebuilder:validation:Required
+	RASecretName string `json:"raSecretName"`
 }
 
 //+kubebuilder:object:root=true
@@ -62,6 +198,20 @@ type EstIssuer struct {
 	Status EstIssuerStatus `json:"status,omitempty"`
 }
 
+const (
+	// IssuerConditionReady is the condition type reflecting whether an issuer is ready to issue certificates.
+	IssuerConditionReady = "Ready"
+	// IssuerConditionTrustAnchorValid is the condition type reflecting whether the TLS trust anchor and the CA bundle
+	// of an issuer are well-formed, and the CA certificates of the EST portal chain to the CA bundle.
+	IssuerConditionTrustAnchorValid = "TrustAnchorValid"
+	// IssuerConditionCredentialsValid is the condition type reflecting whether the Secrets an issuer authenticates
+	// with exist and hold currently valid certificates.
+	IssuerConditionCredentialsValid = "CredentialsValid"
+	// IssuerConditionCACertsReachable is the condition type reflecting whether the EST portal serves its CA
+	// certificates over a trusted connection.
+	IssuerConditionCACertsReachable = "CACertsReachable"
+)
+
 type EstIssuerStatus struct {
 	// +kubebuilder:validation:Optional
 	Ready bool `json:"ready,omitempty"`
@@ -72,6 +222,103 @@ type EstIssuerStatus struct {
 	// +listType=map
 	// +listMapKey=type
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// The time the issuer was last validated.
+	// +kubebuilder:validation:Optional
+	LastCheckedTime *metav1.Time `json:"lastCheckedTime,omitempty"`
+
+	// The generation of the issuer the last validation was based on.
+	// +kubebuilder:validation:Optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// The number of validations that failed in a row, which determines the backoff until the next one.
+	// +kubebuilder:validation:Optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+
+	// The CSR attributes the EST portal requires, as returned by its /csrattrs endpoint (RFC 7030 Sec. 4.5).
+	// +kubebuilder:validation:Optional
+	CSRAttributes *CSRAttributes `json:"csrAttributes,omitempty"`
+
+	// The CA certificates served by the /cacerts endpoint of the EST portal (RFC 7030 Sec. 4.1).
+	// +kubebuilder:validation:Optional
+	CACerts *CACerts `json:"caCerts,omitempty"`
+}
+
+// CACerts are the CA certificates an EST portal distributes.
+type CACerts struct {
+	// The CA certificates in the order served by the EST portal.
+	// +kubebuilder:validation:Optional
+	Certificates []CACertificate `json:"certificates,omitempty"`
+
+	// The time the current CA certificates were first fetched from the EST portal, which is kept while the portal serves the same certificates.
+	// +kubebuilder:validation:Optional
+	LastFetchedTime *metav1.Time `json:"lastFetchedTime,omitempty"`
+}
+
+// CACertificate describes a CA certificate served by an EST portal.
+type CACertificate struct {
+	// The subject distinguished name.
+	Subject string `json:"subject"`
+
+	// The issuer distinguished name.
+	Issuer string `json:"issuer"`
+
+	// The serial number in hexadecimal encoding.
+	SerialNumber string `json:"serialNumber"`
+
+	// The SHA-256 fingerprint of the DER encoding in hexadecimal encoding.
+	Fingerprint string `json:"fingerprint"`
+
+	// The start of the validity period.
+	NotBefore metav1.Time `json:"notBefore"`
+
+	// The end of the validity period.
+	NotAfter metav1.Time `json:"notAfter"`
+
+	// The role of the certificate in a root CA key update as described in RFC 7030 Sec. 4.1.3,
+	// either OldWithNew, NewWithOld or NewWithNew.
+	// +kubebuilder:validation:Optional
+	Rollover CARolloverRole `json:"rollover,omitempty"`
+}
+
+// CARolloverRole is the role of a certificate in a root CA key update.
+// +kubebuilder:validation:Enum=OldWithNew;NewWithOld;NewWithNew
+type CARolloverRole string
+
+const (
+	// CARolloverOldWithNew is the old CA public key signed with the new private key.
+	CARolloverOldWithNew CARolloverRole = "OldWithNew"
+	// CARolloverNewWithOld is the new CA public key signed with the old private key.
+	CARolloverNewWithOld CARolloverRole = "NewWithOld"
+	// CARolloverNewWithNew is the new self-signed CA certificate.
+	CARolloverNewWithNew CARolloverRole = "NewWithNew"
+)
+
+// CSRAttributes are the requirements an EST portal advertises for certificate requests.
+type CSRAttributes struct {
+	// The OIDs the EST portal listed without values, e.g. the signature algorithms requests must be signed with.
+	// +kubebuilder:validation:Optional
+	OIDs []string `json:"oids,omitempty"`
+
+	// The OIDs of the extensions requests must contain.
+	// +kubebuilder:validation:Optional
+	RequiredExtensions []string `json:"requiredExtensions,omitempty"`
+
+	// The public key algorithm requests must use, either ECDSA or RSA.
+	// +kubebuilder:validation:Optional
+	KeyType string `json:"keyType,omitempty"`
+
+	// The OID of the named curve of ECDSA keys.
+	// +kubebuilder:validation:Optional
+	KeyCurve string `json:"keyCurve,omitempty"`
+
+	// The minimum size of RSA keys in bits.
+	// +kubebuilder:validation:Optional
+	KeySize int `json:"keySize,omitempty"`
+
+	// The time the current attributes were first fetched from the EST portal, which is kept while the portal requires the same attributes.
+	// +kubebuilder:validation:Optional
+	LastFetchedTime *metav1.Time `json:"lastFetchedTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
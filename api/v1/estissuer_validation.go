@@ -0,0 +1,148 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// DefaultWellKnownPath is the path the EST operations are served under, as defined in RFC 7030 Sec. 3.2.2.
+const DefaultWellKnownPath = "/.well-known/est"
+
+// BaseURL returns the URL of the portal, which the paths of the EST operations are appended to.
+func (s *EstIssuerSpec) BaseURL() (*url.URL, error) {
+	if s.URL != "" {
+		return url.Parse(s.URL)
+	}
+	host := s.Hostname
+	if s.Port != 0 {
+		host = net.JoinHostPort(s.Hostname, strconv.Itoa(s.Port))
+	}
+	return &url.URL{Scheme: "https", Host: host}, nil
+}
+
+// Path returns the path configured for the EST operation with the given name, e.g. simpleenroll,
+// or an empty string if the operation is served under the well-known path.
+func (p *EstOperationPaths) Path(operation string) string {
+	if p == nil {
+		return ""
+	}
+	switch operation {
+	case "cacerts":
+		return p.CACerts
+	case "simpleenroll":
+		return p.SimpleEnroll
+	case "simplereenroll":
+		return p.SimpleReenroll
+	case "fullcmc":
+		return p.FullCMC
+	case "serverkeygen":
+		return p.ServerKeyGen
+	case "csrattrs":
+		return p.CSRAttrs
+	}
+	return ""
+}
+
+// Validate checks the location of the portal and the combination of the settings, which the schema
+// of the CRD cannot express.
+func (s *EstIssuerSpec) Validate(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	switch {
+	case s.URL != "" && s.Hostname != "":
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("hostname"), "must not be set along with url"))
+	case s.URL == "" && s.Hostname == "":
+		allErrs = append(allErrs, field.Required(fldPath.Child("hostname"), "either hostname or url must be set"))
+	}
+	if s.URL != "" {
+		allErrs = append(allErrs, s.validateURL(fldPath)...)
+	}
+	if s.Hostname != "" {
+		allErrs = append(allErrs, validateHostname(fldPath.Child("hostname"), s.Hostname)...)
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("port"), s.Port, "must be between 1 and 65535"))
+	}
+	if strings.Contains(s.Label, "/") {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("label"), s.Label, "must be a single path segment without slashes"))
+	}
+
+	allErrs = append(allErrs, validatePath(fldPath.Child("wellKnown"), s.WellKnown)...)
+	if s.Paths != nil {
+		pathsPath := fldPath.Child("paths")
+		for _, operation := range []string{"cacerts", "simpleenroll", "simplereenroll", "fullcmc", "serverkeygen", "csrattrs"} {
+			allErrs = append(allErrs, validatePath(pathsPath.Child(operation), s.Paths.Path(operation))...)
+		}
+	}
+	return allErrs
+}
+
+// validateHostname checks that the hostname of the portal is a DNS name or an IP address.
+func validateHostname(fldPath *field.Path, hostname string) field.ErrorList {
+	if net.ParseIP(hostname) != nil {
+		return nil
+	}
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(strings.ToLower(hostname)) {
+		allErrs = append(allErrs, field.Invalid(fldPath, hostname, msg))
+	}
+	return allErrs
+}
+
+// validatePath checks a path the EST operations are served under, which is appended to the url of the portal.
+func validatePath(fldPath *field.Path, p string) field.ErrorList {
+	if strings.ContainsAny(p, "?#") {
+		return field.ErrorList{field.Invalid(fldPath, p, "must not include a query or a fragment")}
+	}
+	return nil
+}
+
+// validateURL checks the url of the portal. Only https is supported, as RFC 7030 Sec. 3.2.3 requires the
+// credentials of the issuer to be sent over TLS.
+func (s *EstIssuerSpec) validateURL(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	urlPath := fldPath.Child("url")
+	if s.Port != 0 {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("port"), "must not be set along with url, which includes the port"))
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return append(allErrs, field.Invalid(urlPath, s.URL, err.Error()))
+	}
+	switch {
+	case u.Scheme != "https":
+		allErrs = append(allErrs, field.NotSupported(urlPath, u.Scheme, []string{"https"}))
+	case u.Hostname() == "":
+		allErrs = append(allErrs, field.Invalid(urlPath, s.URL, "must include a host"))
+	case u.User != nil || u.RawQuery != "" || u.Fragment != "":
+		allErrs = append(allErrs, field.Invalid(urlPath, s.URL, "must not include credentials, a query or a fragment"))
+	}
+	if port := u.Port(); port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			allErrs = append(allErrs, field.Invalid(urlPath, s.URL, "must include a port between 1 and 65535"))
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhookWithManager registers the defaulting and validating webhooks of EstIssuers.
+func (r *EstIssuer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&issuerDefaulter{}).
+		WithValidator(&issuerValidator{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-certmanager-jquad-rocks-v1-estissuer,mutating=true,failurePolicy=fail,sideEffects=None,groups=certmanager.jquad.rocks,resources=estissuers,verbs=create;update,versions=v1,name=mestissuer.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/validate-certmanager-jquad-rocks-v1-estissuer,mutating=false,failurePolicy=fail,sideEffects=None,groups=certmanager.jquad.rocks,resources=estissuers,verbs=create;update,versions=v1,name=vestissuer.kb.io,admissionReviewVersions=v1
+
+// issuerDefaulter defaults the spec of EstIssuers and ClusterEstIssuers.
+type issuerDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &issuerDefaulter{}
+
+func (d *issuerDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	issuer, ok := obj.(GenericIssuer)
+	if !ok {
+		return fmt.Errorf("expected an EstIssuer or ClusterEstIssuer but got %T", obj)
+	}
+	issuer.GetSpec().Default()
+	return nil
+}
+
+// issuerValidator rejects EstIssuers and ClusterEstIssuers whose spec is invalid.
+type issuerValidator struct{}
+
+var _ webhook.CustomValidator = &issuerValidator{}
+
+func (v *issuerValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return validateIssuer(obj)
+}
+
+func (v *issuerValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	return validateIssuer(newObj)
+}
+
+func (v *issuerValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateIssuer validates the spec of an EstIssuer or ClusterEstIssuer and warns about deprecated settings.
+func validateIssuer(obj runtime.Object) (admission.Warnings, error) {
+	issuer, ok := obj.(GenericIssuer)
+	if !ok {
+		return nil, fmt.Errorf("expected an EstIssuer or ClusterEstIssuer but got %T", obj)
+	}
+	spec := issuer.GetSpec()
+
+	var warnings admission.Warnings
+	if spec.Cacert != "" {
+		warnings = append(warnings, "spec.cacert is deprecated, use spec.tlsTrustAnchor")
+	}
+
+	allErrs := spec.Validate(field.NewPath("spec"))
+	allErrs = append(allErrs, spec.validateAdmission(field.NewPath("spec"))...)
+	if len(allErrs) > 0 {
+		kind := EstIssuerKind
+		if _, ok := issuer.(*ClusterEstIssuer); ok {
+			kind = ClusterEstIssuerKind
+		}
+		return warnings, apierrors.NewInvalid(GroupVersion.WithKind(kind).GroupKind(), issuer.GetName(), allErrs)
+	}
+	return warnings, nil
+}
+
+// Default sets the well-known path, if unset. The port of a portal given by its hostname is left unset, which
+// BaseURL resolves to the HTTPS port, so that the issuer can later be switched to a url without clearing it.
+func (s *EstIssuerSpec) Default() {
+	if s.WellKnown == "" {
+		s.WellKnown = DefaultWellKnownPath
+	}
+}
+
+// validateAdmission checks the settings the controller reports through the conditions of the issuer,
+// so that they are already rejected when the issuer is applied.
+func (s *EstIssuerSpec) validateAdmission(fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if s.AuthSecretName == "" && s.ClientCertSecretName == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("authSecretName"), "at least one of authSecretName or clientCertSecretName must be set"))
+	}
+	if s.Cacert != "" {
+		if err := validateEncodedPEMCertificates(s.Cacert); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("cacert"), "<certificates>", err.Error()))
+		}
+	}
+	allErrs = append(allErrs, validateCertificateSource(fldPath.Child("tlsTrustAnchor"), s.TLSTrustAnchor)...)
+	allErrs = append(allErrs, validateCertificateSource(fldPath.Child("caBundle"), s.CABundle)...)
+	return allErrs
+}
+
+// validateCertificateSource checks the inline certificates of a CertificateSource. Certificates read from
+// ConfigMaps and Secrets are checked by the controller, as they may change after admission.
+func validateCertificateSource(fldPath *field.Path, source *CertificateSource) field.ErrorList {
+	if source == nil || source.Inline == "" {
+		return nil
+	}
+	if err := validatePEMCertificates([]byte(source.Inline)); err != nil {
+		return field.ErrorList{field.Invalid(fldPath.Child("inline"), "<certificates>", err.Error())}
+	}
+	return nil
+}
+
+// validateEncodedPEMCertificates checks that data holds base64 encoded PEM certificates.
+func validateEncodedPEMCertificates(data string) error {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return fmt.Errorf("must be base64 encoded: %v", err)
+	}
+	return validatePEMCertificates(decoded)
+}
+
+// validatePEMCertificates checks that data only holds PEM encoded certificates, rejecting blocks of
+// other types and any data outside of PEM blocks.
+func validatePEMCertificates(data []byte) error {
+	rest := bytes.TrimSpace(data)
+	if len(rest) == 0 {
+		return fmt.Errorf("must hold PEM encoded certificates")
+	}
+	for len(rest) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return fmt.Errorf("must hold PEM encoded certificates only")
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block of type %q", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("malformed certificate: %v", err)
+		}
+		rest = bytes.TrimSpace(rest)
+	}
+	return nil
+}
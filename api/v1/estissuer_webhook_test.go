@@ -0,0 +1,185 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("EstIssuer Webhook", func() {
+	var issuer *EstIssuer
+
+	BeforeEach(func() {
+		issuer = &EstIssuer{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-issuer", Namespace: "default"},
+			Spec: EstIssuerSpec{
+				Hostname:       "est.example.com",
+				AuthSecretName: "credentials",
+			},
+		}
+	})
+
+	Context("When creating an EstIssuer under Defaulting Webhook", func() {
+		It("should default the well-known path", func() {
+			Expect((&issuerDefaulter{}).Default(context.Background(), issuer)).To(Succeed())
+			Expect(issuer.Spec.WellKnown).To(Equal("/.well-known/est"))
+
+			issuer.Spec.WellKnown = "/gateway/est"
+			Expect((&issuerDefaulter{}).Default(context.Background(), issuer)).To(Succeed())
+			Expect(issuer.Spec.WellKnown).To(Equal("/gateway/est"))
+		})
+
+		It("should keep the port implicit, so that the issuer can be switched to a url", func() {
+			Expect((&issuerDefaulter{}).Default(context.Background(), issuer)).To(Succeed())
+			Expect(issuer.Spec.Port).To(BeZero())
+			baseURL, err := issuer.Spec.BaseURL()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(baseURL.String()).To(Equal("https://est.example.com"))
+
+			updated := issuer.DeepCopy()
+			updated.Spec.Hostname = ""
+			updated.Spec.URL = "https://est.example.com:8443"
+			Expect((&issuerDefaulter{}).Default(context.Background(), updated)).To(Succeed())
+			_, err = (&issuerValidator{}).ValidateUpdate(context.Background(), issuer, updated)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("When creating or updating an EstIssuer under Validating Webhook", func() {
+		It("should admit a valid issuer", func() {
+			issuer.Spec.Port = 8443
+			issuer.Spec.Cacert = encodeCertificate()
+			warnings, err := (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(ContainSubstring("spec.cacert is deprecated")))
+		})
+
+		It("should reject a cacert which is not base64 encoded PEM", func() {
+			issuer.Spec.Cacert = "-----BEGIN CERTIFICATE-----"
+			_, err := (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("spec.cacert: Invalid value: \"<certificates>\": must be base64 encoded")))
+
+			issuer.Spec.Cacert = base64.StdEncoding.EncodeToString([]byte("not a certificate"))
+			_, err = (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(err).To(MatchError(ContainSubstring("must hold PEM encoded certificates")))
+
+			issuer.Spec.Cacert = base64.StdEncoding.EncodeToString([]byte(pemCertificate() + "trailing garbage"))
+			_, err = (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(err).To(MatchError(ContainSubstring("spec.cacert: Invalid value: \"<certificates>\": must hold PEM encoded certificates only")))
+		})
+
+		It("should admit inline trust anchors and CA bundles", func() {
+			issuer.Spec.TLSTrustAnchor = &CertificateSource{Inline: pemCertificate() + "\n" + pemCertificate()}
+			issuer.Spec.CABundle = &CertificateSource{Inline: pemCertificate()}
+			warnings, err := (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("should reject an inline trust anchor which holds other PEM blocks", func() {
+			key := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}))
+			issuer.Spec.TLSTrustAnchor = &CertificateSource{Inline: pemCertificate() + key}
+			_, err := (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring(`spec.tlsTrustAnchor.inline: Invalid value: "<certificates>": unexpected PEM block of type "PRIVATE KEY"`)))
+		})
+
+		It("should reject an inline CA bundle with data outside of PEM blocks", func() {
+			issuer.Spec.CABundle = &CertificateSource{Inline: pemCertificate() + "trailing garbage"}
+			_, err := (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring(`spec.caBundle.inline: Invalid value: "<certificates>": must hold PEM encoded certificates only`)))
+
+			issuer.Spec.CABundle = &CertificateSource{Inline: "not a certificate"}
+			_, err = (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(err).To(MatchError(ContainSubstring(`spec.caBundle.inline: Invalid value: "<certificates>": must hold PEM encoded certificates only`)))
+		})
+
+		It("should reject invalid hostnames, ports and labels", func() {
+			updated := issuer.DeepCopy()
+			updated.Spec.Hostname = "est_portal.example.com"
+			updated.Spec.Port = 70000
+			updated.Spec.Label = "tenant/a"
+			_, err := (&issuerValidator{}).ValidateUpdate(context.Background(), issuer, updated)
+			Expect(err).To(MatchError(ContainSubstring("spec.hostname: Invalid value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.port: Invalid value")))
+			Expect(err).To(MatchError(ContainSubstring("spec.label: Invalid value")))
+
+			updated.Spec.Hostname, updated.Spec.Port, updated.Spec.Label = "10.0.0.1", 443, "tenant-a"
+			_, err = (&issuerValidator{}).ValidateUpdate(context.Background(), issuer, updated)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject a url which does not use https", func() {
+			issuer.Spec.Hostname = ""
+			issuer.Spec.URL = "http://est-gateway.pki.svc:8080/tenant-a"
+			_, err := (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring(`spec.url: Unsupported value: "http": supported values: "https"`)))
+
+			issuer.Spec.URL = "https://est-gateway.pki.svc:8443/tenant-a"
+			_, err = (&issuerValidator{}).ValidateCreate(context.Background(), issuer)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject a ClusterEstIssuer without authentication", func() {
+			cluster := &ClusterEstIssuer{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-issuer"},
+				Spec:       EstIssuerSpec{Hostname: "est.example.com"},
+			}
+			_, err := (&issuerValidator{}).ValidateCreate(context.Background(), cluster)
+			Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring(`ClusterEstIssuer.certmanager.jquad.rocks "test-cluster-issuer" is invalid`)))
+			Expect(err).To(MatchError(ContainSubstring("spec.authSecretName: Required value")))
+		})
+	})
+})
+
+// encodeCertificate returns a self-signed certificate in the encoding of the cacert field.
+func encodeCertificate() string {
+	return base64.StdEncoding.EncodeToString([]byte(pemCertificate()))
+}
+
+// pemCertificate returns a PEM encoded self-signed certificate.
+func pemCertificate() string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "est.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -30,20 +30,169 @@ type EstOrderSpec struct {
 
 	// The signed PKCS#10 request in PEM encoding, and then base64 encoded. This value is automatically generated by cert-manager and is copied from the CertificateRequest
 	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="request is immutable"
 	Request []byte `json:"request"`
 
+	// Whether the request renews an existing certificate issued by the same issuer.
 	// +kubebuilder:validation:Optional
 	Renewal bool `json:"renewal,omitempty"`
+
+	// The name of the kubernetes.io/tls Secret holding the certificate being renewed and its key. When set for a renewal,
+	// the request is submitted to /simplereenroll authenticated with this certificate (RFC 7030 Sec. 4.2.2).
+	// +kubebuilder:validation:Optional
+	CertificateSecretName string `json:"certificateSecretName,omitempty"`
+
+	// Lets the EST portal generate the private key instead of the requester (RFC 7030 Sec. 4.4). The key in the request is
+	// only used to sign it, the generated key is written together with the issued certificate to a Secret.
+	// +kubebuilder:validation:Optional
+	ServerKeyGen *ServerKeyGen `json:"serverKeyGen,omitempty"`
+}
+
+// ServerKeyGen configures the server-side key generation of an EstOrder.
+type ServerKeyGen struct {
+	// The name of the kubernetes.io/tls Secret the generated private key and the issued certificate are written to.
+	// +kubebuilder:validation:Required
+	SecretName string `json:"secretName"`
+
+	// The name of a kubernetes.io/tls Secret holding the certificate and RSA key the EST portal encrypts the generated
+	// private key for, when additional encryption is employed as described in RFC 7030 Sec. 4.4.1.2.
+	// +kubebuilder:validation:Optional
+	DecryptionSecretName string `json:"decryptionSecretName,omitempty"`
 }
 
+// EstOrderOperation is the EST operation a request is submitted to.
+// +kubebuilder:validation:Enum=simpleenroll;simplereenroll;serverkeygen;fullcmc
+type EstOrderOperation string
+
+const (
+	// EstOrderOperationSimpleEnroll is the initial enrollment authenticated with the credentials of the issuer (RFC 7030 Sec. 4.2.1).
+	EstOrderOperationSimpleEnroll EstOrderOperation = "simpleenroll"
+	// EstOrderOperationSimpleReenroll is the renewal authenticated with the certificate being renewed (RFC 7030 Sec. 4.2.2).
+	EstOrderOperationSimpleReenroll EstOrderOperation = "simplereenroll"
+	// EstOrderOperationServerKeyGen is the enrollment with a private key generated by the EST portal (RFC 7030 Sec. 4.4).
+	EstOrderOperationServerKeyGen EstOrderOperation = "serverkeygen"
+	// EstOrderOperationFullCMC is the enrollment with a Full CMC request signed by an RA (RFC 7030 Sec. 4.3).
+	EstOrderOperationFullCMC EstOrderOperation = "fullcmc"
+)
+
+// EstOrderPhase is the lifecycle phase of an EstOrder.
+// +kubebuilder:validation:Enum=Pending;Submitted;Accepted;Issued;Failed;Denied
+type EstOrderPhase string
+
+const (
+	// EstOrderPhasePending means the request has not been submitted to the EST portal yet,
+	// e.g. because the issuer is not ready.
+	EstOrderPhasePending EstOrderPhase = "Pending"
+	// EstOrderPhaseSubmitted means the request has been sent and the response of the EST portal is outstanding.
+	EstOrderPhaseSubmitted EstOrderPhase = "Submitted"
+	// EstOrderPhaseAccepted means the EST portal answered 202 Accepted and the request is retried later (RFC 7030 Sec. 4.2.3).
+	EstOrderPhaseAccepted EstOrderPhase = "Accepted"
+	// EstOrderPhaseIssued means the certificate has been issued.
+	EstOrderPhaseIssued EstOrderPhase = "Issued"
+	// EstOrderPhaseFailed means the EstOrder failed permanently.
+	EstOrderPhaseFailed EstOrderPhase = "Failed"
+	// EstOrderPhaseDenied means the EST portal denied the request, e.g. for the credentials of the issuer or a
+	// policy of the CA, or the CA answered with the CMC status failed.
+	EstOrderPhaseDenied EstOrderPhase = "Denied"
+)
+
+// EstOrderConditionReady is the condition type reflecting whether the certificate has been issued.
+const EstOrderConditionReady = "Ready"
+
+// EstOrderRequestHashAnnotation records the SHA-256 digest of the PKCS#10 request of an EstOrder created for a
+// CertificateRequest, which is never enrolled under the same name for another request.
+const EstOrderRequestHashAnnotation = "certmanager.jquad.rocks/request-sha256"
+
 // EstOrderStatus defines the observed state of EstOrder
 type EstOrderStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// The lifecycle phase of the EstOrder.
+	// +kubebuilder:validation:Optional
+	Phase EstOrderPhase `json:"phase,omitempty"`
+	// https://github.com/kubernetes-sigs/cli-utils/blob/master/pkg/kstatus/README.md
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// The EST operation the request is submitted to. A renewal falls back to simpleenroll when the
+	// certificate being renewed has expired or is rejected by the EST portal.
+	// +kubebuilder:validation:Optional
+	Operation EstOrderOperation `json:"operation,omitempty"`
+	// Why a renewal was submitted to simpleenroll instead of simplereenroll.
+	// +kubebuilder:validation:Optional
+	OperationMessage string `json:"operationMessage,omitempty"`
+	// The CMC status info of the last Full CMC response.
+	// +kubebuilder:validation:Optional
+	CMC *CMCStatus `json:"cmc,omitempty"`
+	// The certificate issued by the EST portal in PEM encoding.
+	// +kubebuilder:validation:Optional
+	Certificate []byte `json:"certificate,omitempty"`
+	// The remaining certificates of the PKCS#7 response returned by the EST portal in PEM encoding.
+	// +kubebuilder:validation:Optional
+	Chain []byte `json:"chain,omitempty"`
+	// The serial number of the issued certificate in hexadecimal notation.
+	// +kubebuilder:validation:Optional
+	SerialNumber string `json:"serialNumber,omitempty"`
+	// The start of the validity period of the issued certificate.
+	// +kubebuilder:validation:Optional
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	// The end of the validity period of the issued certificate.
+	// +kubebuilder:validation:Optional
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+	// The HTTP status code of the last response of the EST portal.
+	// +kubebuilder:validation:Optional
+	LastHTTPStatusCode int `json:"lastHTTPStatusCode,omitempty"`
+	// The number of times the request has been submitted to the EST portal.
+	// +kubebuilder:validation:Optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// The number of submissions in a row which failed for a retryable reason, e.g. an error of the EST portal.
+	// The request is submitted again after an exponential backoff.
+	// +kubebuilder:validation:Optional
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+	// The time at which the request was last sent to the EST portal. A request found in the Submitted phase, whose
+	// response was never recorded, e.g. due to a restart, is polled after the retry interval instead of being sent right away.
+	// +kubebuilder:validation:Optional
+	SubmittedTime *metav1.Time `json:"submittedTime,omitempty"`
+	// The time at which the request is submitted to the EST portal again.
+	// +kubebuilder:validation:Optional
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+	// The time at which the EST portal first deferred the request with 202 Accepted. The request fails if it is not issued within the pending timeout of the issuer.
+	// +kubebuilder:validation:Optional
+	AcceptedTime *metav1.Time `json:"acceptedTime,omitempty"`
+	// The time at which the EstOrder failed permanently. A failed EstOrder is never submitted again.
+	// +kubebuilder:validation:Optional
+	FailureTime *metav1.Time `json:"failureTime,omitempty"`
+	// A human readable description of the permanent failure.
+	// +kubebuilder:validation:Optional
+	FailureMessage string `json:"failureMessage,omitempty"`
+	// The time at which the EstOrder was issued, failed or denied, from which on it is retained.
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// CMCStatus is the status info of a Full CMC response as described in RFC 5272 Sec. 6.1.
+type CMCStatus struct {
+	// The CMC status, e.g. success, failed or pending.
+	// +kubebuilder:validation:Optional
+	Status string `json:"status,omitempty"`
+	// The reason of a failure, e.g. badRequest or badIdentity, or the OID of an extended failure reason.
+	// +kubebuilder:validation:Optional
+	FailInfo string `json:"failInfo,omitempty"`
+	// The human readable status of the CA.
+	// +kubebuilder:validation:Optional
+	StatusString string `json:"statusString,omitempty"`
+	// The token identifying a pending request when it is polled.
+	// +kubebuilder:validation:Optional
+	PendToken []byte `json:"pendToken,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Expiry",type="date",JSONPath=".status.notAfter"
+//+kubebuilder:printcolumn:name="Attempts",type="integer",JSONPath=".status.attempts",priority=1
+//+kubebuilder:printcolumn:name="Operation",type="string",JSONPath=".status.operation",priority=1
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // EstOrder is the Schema for the estorders API
 type EstOrder struct {
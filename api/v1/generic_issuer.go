@@ -0,0 +1,71 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// EstIssuerKind is the kind of the namespaced EST issuer.
+	EstIssuerKind = "EstIssuer"
+	// ClusterEstIssuerKind is the kind of the cluster scoped EST issuer.
+	ClusterEstIssuerKind = "ClusterEstIssuer"
+)
+
+// GenericIssuer is implemented by EstIssuer and ClusterEstIssuer, so that both kinds of issuers
+// can be resolved and used in the same way.
+// +kubebuilder:object:generate=false
+type GenericIssuer interface {
+	runtime.Object
+	metav1.Object
+
+	GetSpec() *EstIssuerSpec
+	GetStatus() *EstIssuerStatus
+	// GetSecretNamespace returns the namespace in which the Secrets referenced by the issuer are looked up.
+	GetSecretNamespace(clusterResourceNamespace string) string
+}
+
+var _ GenericIssuer = &EstIssuer{}
+var _ GenericIssuer = &ClusterEstIssuer{}
+
+func (i *EstIssuer) GetSpec() *EstIssuerSpec {
+	return &i.Spec
+}
+
+func (i *EstIssuer) GetStatus() *EstIssuerStatus {
+	return &i.Status
+}
+
+// GetSecretNamespace returns the namespace of the EstIssuer, which may only refer to Secrets next to it.
+func (i *EstIssuer) GetSecretNamespace(_ string) string {
+	return i.Namespace
+}
+
+func (i *ClusterEstIssuer) GetSpec() *EstIssuerSpec {
+	return &i.Spec
+}
+
+func (i *ClusterEstIssuer) GetStatus() *EstIssuerStatus {
+	return &i.Status
+}
+
+// GetSecretNamespace returns the cluster resource namespace, as a ClusterEstIssuer has no namespace of its own.
+func (i *ClusterEstIssuer) GetSecretNamespace(clusterResourceNamespace string) string {
+	return clusterResourceNamespace
+}
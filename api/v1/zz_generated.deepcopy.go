@@ -22,15 +22,147 @@ package v1
 
 import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CACertificate) DeepCopyInto(out *CACertificate) {
+	*out = *in
+	in.NotBefore.DeepCopyInto(&out.NotBefore)
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CACertificate.
+func (in *CACertificate) DeepCopy() *CACertificate {
+	if in == nil {
+		return nil
+	}
+	out := new(CACertificate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CACerts) DeepCopyInto(out *CACerts) {
+	*out = *in
+	if in.Certificates != nil {
+		in, out := &in.Certificates, &out.Certificates
+		*out = make([]CACertificate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastFetchedTime != nil {
+		in, out := &in.LastFetchedTime, &out.LastFetchedTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CACerts.
+func (in *CACerts) DeepCopy() *CACerts {
+	if in == nil {
+		return nil
+	}
+	out := new(CACerts)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CACertsTarget) DeepCopyInto(out *CACertsTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CACertsTarget.
+func (in *CACertsTarget) DeepCopy() *CACertsTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(CACertsTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CMCStatus) DeepCopyInto(out *CMCStatus) {
+	*out = *in
+	if in.PendToken != nil {
+		in, out := &in.PendToken, &out.PendToken
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CMCStatus.
+func (in *CMCStatus) DeepCopy() *CMCStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CMCStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CSRAttributes) DeepCopyInto(out *CSRAttributes) {
+	*out = *in
+	if in.OIDs != nil {
+		in, out := &in.OIDs, &out.OIDs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequiredExtensions != nil {
+		in, out := &in.RequiredExtensions, &out.RequiredExtensions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastFetchedTime != nil {
+		in, out := &in.LastFetchedTime, &out.LastFetchedTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CSRAttributes.
+func (in *CSRAttributes) DeepCopy() *CSRAttributes {
+	if in == nil {
+		return nil
+	}
+	out := new(CSRAttributes)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateSource) DeepCopyInto(out *CertificateSource) {
+	*out = *in
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(KeySelector)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(KeySelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateSource.
+func (in *CertificateSource) DeepCopy() *CertificateSource {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ClusterEstIssuer) DeepCopyInto(out *ClusterEstIssuer) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -89,7 +221,7 @@ func (in *EstIssuer) DeepCopyInto(out *EstIssuer) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -146,6 +278,41 @@ func (in *EstIssuerList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EstIssuerSpec) DeepCopyInto(out *EstIssuerSpec) {
 	*out = *in
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = new(EstOperationPaths)
+		**out = **in
+	}
+	if in.TLSTrustAnchor != nil {
+		in, out := &in.TLSTrustAnchor, &out.TLSTrustAnchor
+		*out = new(CertificateSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CABundle != nil {
+		in, out := &in.CABundle, &out.CABundle
+		*out = new(CertificateSource)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CACertsTarget != nil {
+		in, out := &in.CACertsTarget, &out.CACertsTarget
+		*out = new(CACertsTarget)
+		**out = **in
+	}
+	if in.FullCMC != nil {
+		in, out := &in.FullCMC, &out.FullCMC
+		*out = new(FullCMC)
+		**out = **in
+	}
+	if in.HealthCheckInterval != nil {
+		in, out := &in.HealthCheckInterval, &out.HealthCheckInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.PendingTimeout != nil {
+		in, out := &in.PendingTimeout, &out.PendingTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EstIssuerSpec.
@@ -168,6 +335,20 @@ func (in *EstIssuerStatus) DeepCopyInto(out *EstIssuerStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.LastCheckedTime != nil {
+		in, out := &in.LastCheckedTime, &out.LastCheckedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CSRAttributes != nil {
+		in, out := &in.CSRAttributes, &out.CSRAttributes
+		*out = new(CSRAttributes)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CACerts != nil {
+		in, out := &in.CACerts, &out.CACerts
+		*out = new(CACerts)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EstIssuerStatus.
@@ -180,13 +361,28 @@ func (in *EstIssuerStatus) DeepCopy() *EstIssuerStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EstOperationPaths) DeepCopyInto(out *EstOperationPaths) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EstOperationPaths.
+func (in *EstOperationPaths) DeepCopy() *EstOperationPaths {
+	if in == nil {
+		return nil
+	}
+	out := new(EstOperationPaths)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EstOrder) DeepCopyInto(out *EstOrder) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EstOrder.
@@ -243,6 +439,16 @@ func (in *EstOrderList) DeepCopyObject() runtime.Object {
 func (in *EstOrderSpec) DeepCopyInto(out *EstOrderSpec) {
 	*out = *in
 	out.IssuerRef = in.IssuerRef
+	if in.Request != nil {
+		in, out := &in.Request, &out.Request
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServerKeyGen != nil {
+		in, out := &in.ServerKeyGen, &out.ServerKeyGen
+		*out = new(ServerKeyGen)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EstOrderSpec.
@@ -258,6 +464,56 @@ func (in *EstOrderSpec) DeepCopy() *EstOrderSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EstOrderStatus) DeepCopyInto(out *EstOrderStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.CMC != nil {
+		in, out := &in.CMC, &out.CMC
+		*out = new(CMCStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.Chain != nil {
+		in, out := &in.Chain, &out.Chain
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.NotBefore != nil {
+		in, out := &in.NotBefore, &out.NotBefore
+		*out = (*in).DeepCopy()
+	}
+	if in.NotAfter != nil {
+		in, out := &in.NotAfter, &out.NotAfter
+		*out = (*in).DeepCopy()
+	}
+	if in.SubmittedTime != nil {
+		in, out := &in.SubmittedTime, &out.SubmittedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.NextRetryTime != nil {
+		in, out := &in.NextRetryTime, &out.NextRetryTime
+		*out = (*in).DeepCopy()
+	}
+	if in.AcceptedTime != nil {
+		in, out := &in.AcceptedTime, &out.AcceptedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.FailureTime != nil {
+		in, out := &in.FailureTime, &out.FailureTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EstOrderStatus.
@@ -270,6 +526,21 @@ func (in *EstOrderStatus) DeepCopy() *EstOrderStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FullCMC) DeepCopyInto(out *FullCMC) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FullCMC.
+func (in *FullCMC) DeepCopy() *FullCMC {
+	if in == nil {
+		return nil
+	}
+	out := new(FullCMC)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *IssuerRef) DeepCopyInto(out *IssuerRef) {
 	*out = *in
@@ -284,3 +555,33 @@ func (in *IssuerRef) DeepCopy() *IssuerRef {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeySelector) DeepCopyInto(out *KeySelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeySelector.
+func (in *KeySelector) DeepCopy() *KeySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(KeySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServerKeyGen) DeepCopyInto(out *ServerKeyGen) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServerKeyGen.
+func (in *ServerKeyGen) DeepCopy() *ServerKeyGen {
+	if in == nil {
+		return nil
+	}
+	out := new(ServerKeyGen)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -20,11 +20,13 @@ import (
 	"crypto/tls"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	certManagerApi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -47,6 +49,7 @@ var (
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
+	utilruntime.Must(certManagerApi.AddToScheme(scheme))
 	utilruntime.Must(certmanagerv1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
@@ -57,6 +60,8 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var clusterResourceNamespace string
+	var estOrderRetention time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -66,6 +71,12 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&clusterResourceNamespace, "cluster-resource-namespace", "est-operator-system",
+		"The namespace in which the Secrets referenced by ClusterEstIssuers are looked up.")
+	flag.DurationVar(&estOrderRetention, "estorder-retention", 0,
+		"How long completed EstOrders are kept before they are deleted. "+
+			"Zero keeps them until they are garbage collected with their CertificateRequest. "+
+			"EstOrders with server-side key generation are always kept, as they own the Secret of the generated key.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -123,22 +134,28 @@ func main() {
 	}
 
 	if err = (&controller.EstIssuerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("estissuer-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "EstIssuer")
 		os.Exit(1)
 	}
 	if err = (&controller.ClusterEstIssuerReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                   mgr.GetClient(),
+		Scheme:                   mgr.GetScheme(),
+		Recorder:                 mgr.GetEventRecorderFor("clusterestissuer-controller"),
+		ClusterResourceNamespace: clusterResourceNamespace,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterEstIssuer")
 		os.Exit(1)
 	}
 	if err = (&controller.EstOrderReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                   mgr.GetClient(),
+		Log:                      ctrl.Log.WithName("controllers").WithName("EstOrder"),
+		Scheme:                   mgr.GetScheme(),
+		ClusterResourceNamespace: clusterResourceNamespace,
+		CompletedRetention:       estOrderRetention,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "EstOrder")
 		os.Exit(1)
@@ -150,6 +167,17 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "CertManagerCertificateRequest")
 		os.Exit(1)
 	}
+	// the webhooks can be disabled to run the manager locally without serving certificates
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&certmanagerv1.EstIssuer{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "EstIssuer")
+			os.Exit(1)
+		}
+		if err = (&certmanagerv1.ClusterEstIssuer{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterEstIssuer")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -17,13 +17,29 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	apiutil "github.com/cert-manager/cert-manager/pkg/api/util"
 	certManagerApi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	certmanagerv1 "github.com/jquad-group/est-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/pointer"
@@ -31,7 +47,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
-	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -40,7 +55,8 @@ import (
 )
 
 const (
-	issueRefNameField = ".spec.issueref.name"
+	// certificateRequestIssuerRefField indexes CertificateRequests for EST issuers by the kind and name of their issuer.
+	certificateRequestIssuerRefField = ".spec.issuerRef"
 )
 
 // CertManagerCertificateRequestReconciler reconciles a CertManagerCertificateRequest object
@@ -49,27 +65,37 @@ type CertManagerCertificateRequestReconciler struct {
 	Scheme *runtime.Scheme
 }
 
-//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=certmanagercertificaterequests,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=certmanagercertificaterequests/status,verbs=get;update;patch
-//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=certmanagercertificaterequests/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estorders,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estissuers,verbs=get;list;watch
+//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=clusterestissuers,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the CertManagerCertificateRequest object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// An EstOrder is created for every CertificateRequest referencing an EST issuer,
+// and once the EstOrder completes, the issued certificate and CA or the failure
+// is written back to the CertificateRequest status.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.2/pkg/reconcile
 func (r *CertManagerCertificateRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	log := log.FromContext(ctx)
 
 	var certificateRequest certManagerApi.CertificateRequest
 	if err := r.Get(ctx, req.NamespacedName, &certificateRequest); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// the request has already been issued or failed
+	if isCertificateRequestCompleted(&certificateRequest) {
+		return ctrl.Result{}, nil
+	}
+	observedStatus := ownedCertificateRequestStatus(certificateRequest.Status)
+
 	patch := &unstructured.Unstructured{}
 	patch.SetGroupVersionKind(schema.GroupVersionKind{
 		Group:   "cert-manager.io",
@@ -87,102 +113,392 @@ func (r *CertManagerCertificateRequestReconciler) Reconcile(ctx context.Context,
 		PatchOptions: *patchOptions,
 	}
 
+	// a denied request is failed without ever being submitted to the EST portal
+	if apiutil.CertificateRequestIsDenied(&certificateRequest) {
+		certificateRequest.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		apiutil.SetCertificateRequestCondition(&certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+			certManagerApi.CertificateRequestReasonDenied, "The CertificateRequest was denied by an approval controller")
+		patch.UnstructuredContent()["status"] = ownedCertificateRequestStatus(certificateRequest.Status)
+		log.Info("CertificateRequest has been denied")
+		return ctrl.Result{}, r.Status().Patch(ctx, patch, client.Apply, subPatchOptions)
+	}
+
+	// wait for an approval controller such as approver-policy, approving the request triggers a new reconcile
+	if !apiutil.CertificateRequestIsApproved(&certificateRequest) {
+		log.V(1).Info("CertificateRequest has not been approved yet")
+		return ctrl.Result{}, nil
+	}
+
+	issuerRef := certmanagerv1.IssuerRef{
+		Kind:  certificateRequest.Spec.IssuerRef.Kind,
+		Group: certificateRequest.Spec.IssuerRef.Group,
+		Name:  certificateRequest.Spec.IssuerRef.Name,
+	}
+
 	// check if the referenced issuer in the certificate requests is ready
-	var issuer certmanagerv1.EstIssuer
-	if err := r.Get(ctx, types.NamespacedName{Name: certificateRequest.Spec.IssuerRef.Name, Namespace: certificateRequest.Namespace}, &issuer); err != nil {
+	issuer, err := getIssuerFromResource(ctx, r.Client, issuerRef, certificateRequest.Namespace)
+	switch {
+	case errors.Is(err, errIssuerGroupNotSupported):
+		// the request is served by another issuer
+		return ctrl.Result{}, nil
+	case errors.Is(err, errIssuerKindNotSupported):
+		certificateRequest.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		apiutil.SetCertificateRequestCondition(&certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+			certManagerApi.CertificateRequestReasonFailed, fmt.Sprintf("Invalid issuer reference: %v", err))
+		patch.UnstructuredContent()["status"] = ownedCertificateRequestStatus(certificateRequest.Status)
+		return ctrl.Result{}, r.Status().Patch(ctx, patch, client.Apply, subPatchOptions)
+	case err != nil:
 		return ctrl.Result{}, err
 	}
-	if !issuer.Status.Ready {
-		return ctrl.Result{}, fmt.Errorf("issuer %s is not ready", issuer.Name)
+	if !issuer.GetStatus().Ready {
+		return ctrl.Result{}, fmt.Errorf("%s %s is not ready", issuerRef.Kind, issuer.GetName())
+	}
+
+	// fail fast on requests the EST portal would reject for not matching its CSR attributes
+	if err := validateCertificateRequestAttributes(certificateRequest.Spec.Request, issuer.GetStatus().CSRAttributes); err != nil {
+		certificateRequest.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		apiutil.SetCertificateRequestCondition(&certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+			certManagerApi.CertificateRequestReasonFailed, fmt.Sprintf("The request does not satisfy the CSR attributes of %s %s: %v", issuerRef.Kind, issuer.GetName(), err))
+		patch.UnstructuredContent()["status"] = ownedCertificateRequestStatus(certificateRequest.Status)
+		return ctrl.Result{}, r.Status().Patch(ctx, patch, client.Apply, subPatchOptions)
+	}
+
+	// the EstOrder is only created once, as its request may have been sent to the EST portal already
+	estOrder, err := r.ensureEstOrder(ctx, &certificateRequest, issuerRef, patchOptions)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Update the status of the CertificateRequest from the EstOrder
+	switch {
+	case len(estOrder.Status.Certificate) > 0:
+		certificate, ca, err := buildCertificateChain(estOrder.Status)
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to read certificates of EstOrder %s: %w", estOrder.Name, err)
+		}
+		certificateRequest.Status.Certificate = certificate
+		certificateRequest.Status.CA = ca
+		apiutil.SetCertificateRequestCondition(&certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionTrue,
+			certManagerApi.CertificateRequestReasonIssued, "Certificate fetched from EstOrder "+estOrder.Name)
+		log.Info("Certificate issued", "estorder", estOrder.Name)
+	case estOrder.Status.FailureTime != nil:
+		certificateRequest.Status.FailureTime = estOrder.Status.FailureTime
+		apiutil.SetCertificateRequestCondition(&certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+			certManagerApi.CertificateRequestReasonFailed, fmt.Sprintf("EstOrder %s %s: %s", estOrder.Name, strings.ToLower(string(estOrder.Status.Phase)), estOrder.Status.FailureMessage))
+	case estOrder.Status.Phase != "":
+		apiutil.SetCertificateRequestCondition(&certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+			certManagerApi.CertificateRequestReasonPending, fmt.Sprintf("EstOrder %s is %s", estOrder.Name, estOrder.Status.Phase))
+	default:
+		apiutil.SetCertificateRequestCondition(&certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionFalse,
+			certManagerApi.CertificateRequestReasonPending, "Created new EstOrder "+estOrder.Name)
+	}
+
+	// the status is only applied if it changed, as the request is reconciled on every change of its EstOrder
+	status := ownedCertificateRequestStatus(certificateRequest.Status)
+	if equality.Semantic.DeepEqual(status, observedStatus) {
+		return ctrl.Result{}, nil
+	}
+	patch.UnstructuredContent()["status"] = status
+	if err := r.Status().Patch(ctx, patch, client.Apply, subPatchOptions); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// ensureEstOrder returns the EstOrder of the CertificateRequest, which shares its name, and creates it if it does
+// not exist yet. An existing EstOrder is never applied again, unless it lacks its owner reference, and one created
+// for another request, e.g. of a deleted CertificateRequest of the same name, is not taken over.
+func (r *CertManagerCertificateRequestReconciler) ensureEstOrder(ctx context.Context, certificateRequest *certManagerApi.CertificateRequest,
+	issuerRef certmanagerv1.IssuerRef, patchOptions *client.PatchOptions) (*certmanagerv1.EstOrder, error) {
+	requestHash := certificateRequestHash(certificateRequest.Spec.Request)
+
+	var existing certmanagerv1.EstOrder
+	err := r.Get(ctx, client.ObjectKeyFromObject(certificateRequest), &existing)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+	found := err == nil
+	if found {
+		if certificateRequestHash(existing.Spec.Request) != requestHash {
+			return nil, fmt.Errorf("EstOrder %s was created for another request", existing.Name)
+		}
+		if metav1.GetControllerOf(&existing) != nil {
+			return &existing, nil
+		}
 	}
 
-	// create est order
 	estOrder := certmanagerv1.EstOrder{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: certmanagerv1.GroupVersion.String(),
+			Kind:       "EstOrder",
+		},
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      certificateRequest.Name,
 			Namespace: certificateRequest.Namespace,
-		},
-		Spec: certmanagerv1.EstOrderSpec{
-			IssuerRef: certmanagerv1.IssuerRef{
-				Kind:  certificateRequest.Spec.IssuerRef.Kind,
-				Group: certificateRequest.Spec.IssuerRef.Group,
-				Name:  certificateRequest.Spec.IssuerRef.Name,
+			Annotations: map[string]string{
+				certmanagerv1.EstOrderRequestHashAnnotation: requestHash,
 			},
-			Request: certificateRequest.Spec.Request,
-			Renewal: false,
 		},
+		Spec: existing.Spec,
+	}
+	if !found {
+		// a request for a Certificate holding a valid certificate of the same issuer is a renewal
+		renewedSecretName, err := r.findRenewedCertificateSecret(ctx, certificateRequest)
+		if err != nil {
+			return nil, err
+		}
+		estOrder.Spec = certmanagerv1.EstOrderSpec{
+			IssuerRef:             issuerRef,
+			Request:               certificateRequest.Spec.Request,
+			Renewal:               renewedSecretName != "",
+			CertificateSecretName: renewedSecretName,
+		}
 	}
 
-	if err := r.Patch(ctx, &estOrder, client.Apply, subPatchOptions); err != nil {
-		return ctrl.Result{}, err
+	// the EstOrder is garbage collected with the CertificateRequest
+	if err := ctrl.SetControllerReference(certificateRequest, &estOrder, r.Scheme); err != nil {
+		return nil, err
 	}
+	if err := r.Patch(ctx, &estOrder, client.Apply, patchOptions); err != nil {
+		return nil, err
+	}
+	return &estOrder, nil
+}
 
-	// set owner reference
-	if err := ctrl.SetControllerReference(&certificateRequest, &estOrder, r.Scheme); err != nil {
-		return ctrl.Result{}, err
+// certificateRequestHash returns the hex encoded SHA-256 digest of a PKCS#10 request.
+func certificateRequestHash(request []byte) string {
+	digest := sha256.Sum256(request)
+	return hex.EncodeToString(digest[:])
+}
+
+// findRenewedCertificateSecret returns the name of the Secret of the Certificate the request was created for,
+// if it holds a currently valid certificate issued by the issuer of the request. Otherwise an empty name is
+// returned, and the request is enrolled like a new one.
+func (r *CertManagerCertificateRequestReconciler) findRenewedCertificateSecret(ctx context.Context, certificateRequest *certManagerApi.CertificateRequest) (string, error) {
+	certificateName := certificateRequest.Annotations[certManagerApi.CertificateNameKey]
+	if certificateName == "" {
+		return "", nil
 	}
 
-	// Update the status of the CertificateRequest
-	condition := certManagerApi.CertificateRequestCondition{
-		Type:               certManagerApi.CertificateRequestConditionReady,
-		Status:             "False",
-		Reason:             "Pending",
-		Message:            "Created new EstOrder " + estOrder.Name,
-		LastTransitionTime: &metav1.Time{Time: time.Now()},
+	var certificate certManagerApi.Certificate
+	if err := r.Get(ctx, types.NamespacedName{Name: certificateName, Namespace: certificateRequest.Namespace}, &certificate); err != nil {
+		return "", client.IgnoreNotFound(err)
 	}
-	certificateRequest.Status.Conditions = append(certificateRequest.Status.Conditions, condition)
 
-	patch.UnstructuredContent()["status"] = certificateRequest.Status
-	if err := r.Status().Patch(ctx, patch, client.Apply, subPatchOptions); err != nil {
-		return ctrl.Result{}, err
+	var secret corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Name: certificate.Spec.SecretName, Namespace: certificateRequest.Namespace}, &secret); err != nil {
+		return "", client.IgnoreNotFound(err)
 	}
 
-	return ctrl.Result{}, nil
+	issuerRef := certificateRequest.Spec.IssuerRef
+	if secret.Annotations[certManagerApi.IssuerNameAnnotationKey] != issuerRef.Name ||
+		secret.Annotations[certManagerApi.IssuerKindAnnotationKey] != issuerRef.Kind ||
+		secret.Annotations[certManagerApi.IssuerGroupAnnotationKey] != issuerRef.Group {
+		return "", nil
+	}
+
+	cert, err := loadClientCertificate(secret)
+	if err != nil || checkCertificateValidity(cert.Leaf, time.Now()) != nil {
+		return "", nil
+	}
+	return secret.Name, nil
+}
+
+var (
+	signatureAlgorithmOIDs = map[string]x509.SignatureAlgorithm{
+		"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+		"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+		"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+		"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+		"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+		"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+		"1.3.101.112":           x509.PureEd25519,
+	}
+	namedCurveOIDs = map[string]elliptic.Curve{
+		"1.2.840.10045.3.1.7": elliptic.P256(),
+		"1.3.132.0.34":        elliptic.P384(),
+		"1.3.132.0.35":        elliptic.P521(),
+	}
+)
+
+// validateCertificateRequestAttributes checks a PEM encoded PKCS#10 request against the CSR attributes of an
+// issuer: the required extensions, the public key algorithm, curve and size, and the signature algorithm if
+// the attributes list any. All violations are reported at once.
+func validateCertificateRequestAttributes(request []byte, attrs *certmanagerv1.CSRAttributes) error {
+	csr, err := decodeCertificateRequest(request)
+	if err != nil {
+		return err
+	}
+	if attrs == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, required := range attrs.RequiredExtensions {
+		if !slices.ContainsFunc(csr.Extensions, func(ext pkix.Extension) bool { return ext.Id.String() == required }) {
+			errs = append(errs, fmt.Errorf("missing extension %s", required))
+		}
+	}
+
+	switch attrs.KeyType {
+	case "ECDSA":
+		pub, ok := csr.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			errs = append(errs, fmt.Errorf("public key algorithm is %s, expected ECDSA", csr.PublicKeyAlgorithm))
+		} else if curve, known := namedCurveOIDs[attrs.KeyCurve]; known && pub.Curve != curve {
+			errs = append(errs, fmt.Errorf("public key curve is %s, expected %s", pub.Curve.Params().Name, curve.Params().Name))
+		}
+	case "RSA":
+		pub, ok := csr.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			errs = append(errs, fmt.Errorf("public key algorithm is %s, expected RSA", csr.PublicKeyAlgorithm))
+		} else if attrs.KeySize > 0 && pub.N.BitLen() < attrs.KeySize {
+			errs = append(errs, fmt.Errorf("public key size is %d bits, expected at least %d", pub.N.BitLen(), attrs.KeySize))
+		}
+	}
+
+	var signatureAlgorithms []x509.SignatureAlgorithm
+	for _, oid := range attrs.OIDs {
+		if algorithm, ok := signatureAlgorithmOIDs[oid]; ok {
+			signatureAlgorithms = append(signatureAlgorithms, algorithm)
+		}
+	}
+	if len(signatureAlgorithms) > 0 && !slices.Contains(signatureAlgorithms, csr.SignatureAlgorithm) {
+		errs = append(errs, fmt.Errorf("signature algorithm is %s, expected one of %v", csr.SignatureAlgorithm, signatureAlgorithms))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// ownedCertificateRequestStatus returns the status fields managed by this controller, so the
+// apply patch does not take over the conditions of cert-manager such as Approved or Denied.
+func ownedCertificateRequestStatus(status certManagerApi.CertificateRequestStatus) certManagerApi.CertificateRequestStatus {
+	owned := certManagerApi.CertificateRequestStatus{
+		Certificate: status.Certificate,
+		CA:          status.CA,
+		FailureTime: status.FailureTime,
+	}
+	for _, condition := range status.Conditions {
+		if condition.Type == certManagerApi.CertificateRequestConditionReady {
+			owned.Conditions = append(owned.Conditions, condition)
+		}
+	}
+	return owned
+}
+
+// buildCertificateChain splits the certificates of a completed EstOrder into the PEM bundle of the
+// issued certificate and its intermediates, and the PEM bundle of the root CAs as expected by cert-manager.
+func buildCertificateChain(status certmanagerv1.EstOrderStatus) ([]byte, []byte, error) {
+	chain, err := parseCertificates(status.Chain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var intermediates, roots []*x509.Certificate
+	for _, cert := range chain {
+		if isSelfSigned(cert) {
+			roots = append(roots, cert)
+		} else {
+			intermediates = append(intermediates, cert)
+		}
+	}
+
+	certificate := append(bytes.Clone(status.Certificate), encodeCertificates(intermediates)...)
+	return certificate, encodeCertificates(roots), nil
+}
+
+// isSelfSigned reports whether the certificate is a self-signed root.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// isEstCertificateRequest reports whether the CertificateRequest references an issuer of the certmanager.jquad.rocks
+// group. The kind is only checked when the request is reconciled, so that a reference to a kind other than EstIssuer
+// or ClusterEstIssuer fails the request instead of leaving it pending.
+func isEstCertificateRequest(certificateRequest *certManagerApi.CertificateRequest) bool {
+	return certificateRequest.Spec.IssuerRef.Group == certmanagerv1.GroupVersion.Group
+}
+
+// isCertificateRequestCompleted reports whether the CertificateRequest has been issued, failed or denied.
+func isCertificateRequestCompleted(certificateRequest *certManagerApi.CertificateRequest) bool {
+	switch apiutil.CertificateRequestReadyReason(certificateRequest) {
+	case certManagerApi.CertificateRequestReasonIssued, certManagerApi.CertificateRequestReasonFailed, certManagerApi.CertificateRequestReasonDenied:
+		return true
+	}
+	return false
+}
+
+// isPendingEstCertificateRequest filters the CertificateRequest events down to requests for an EST issuer
+// which have been approved or denied, and are neither issued, failed nor marked as denied yet. Status
+// changes are not ignored, as a request is approved or denied by adding a condition.
+func isPendingEstCertificateRequest(obj client.Object) bool {
+	certificateRequest, ok := obj.(*certManagerApi.CertificateRequest)
+	if !ok || !isEstCertificateRequest(certificateRequest) {
+		return false
+	}
+
+	if isCertificateRequestCompleted(certificateRequest) {
+		return false
+	}
+
+	return apiutil.CertificateRequestIsApproved(certificateRequest) || apiutil.CertificateRequestIsDenied(certificateRequest)
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *CertManagerCertificateRequestReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &certManagerApi.CertificateRequest{}, issueRefNameField, func(rawObj client.Object) []string {
-		// Extract the issue ref from the CertificateRequest Spec
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &certManagerApi.CertificateRequest{}, certificateRequestIssuerRefField, func(rawObj client.Object) []string {
+		// Extract the issuer ref from the CertificateRequest Spec
 		certificateRequest := rawObj.(*certManagerApi.CertificateRequest)
-		if certificateRequest.Spec.IssuerRef.Kind != "EstIssuer" {
+		if !isEstCertificateRequest(certificateRequest) {
 			return nil
 		}
-		return []string{certificateRequest.Spec.IssuerRef.Name}
+		return []string{issuerRefKey(certificateRequest.Spec.IssuerRef.Kind, certificateRequest.Spec.IssuerRef.Name)}
 	}); err != nil {
 		return err
 	}
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&certManagerApi.CertificateRequest{},
-			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+			builder.WithPredicates(predicate.NewPredicateFuncs(isPendingEstCertificateRequest))).
+		Watches(
+			&certmanagerv1.EstIssuer{},
+			handler.EnqueueRequestsFromMapFunc(r.findCertificateRequestsForIssuer),
+			builder.WithPredicates(issuerBecameReady()),
+		).
 		Watches(
-			&certManagerApi.CertificateRequest{},
-			handler.EnqueueRequestsFromMapFunc(r.findObjectsForEstIssuer),
-			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+			&certmanagerv1.ClusterEstIssuer{},
+			handler.EnqueueRequestsFromMapFunc(r.findCertificateRequestsForIssuer),
+			builder.WithPredicates(issuerBecameReady()),
 		).
+		Owns(&certmanagerv1.EstOrder{},
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
 		Complete(r)
 }
 
-func (r *CertManagerCertificateRequestReconciler) findObjectsForEstIssuer(ctx context.Context, source client.Object) []reconcile.Request {
-	attachedCertificates := &certmanagerv1.EstIssuerList{}
-	listOps := &client.ListOptions{
-		FieldSelector: fields.OneTermEqualSelector(issueRefNameField, source.GetName()),
-		Namespace:     source.GetNamespace(),
-	}
-	err := r.List(context.TODO(), attachedCertificates, listOps)
-	if err != nil {
-		return []reconcile.Request{}
+// findCertificateRequestsForIssuer maps an EstIssuer or ClusterEstIssuer to the pending CertificateRequests
+// referencing it, which are retried once the issuer becomes ready.
+func (r *CertManagerCertificateRequestReconciler) findCertificateRequestsForIssuer(ctx context.Context, issuer client.Object) []reconcile.Request {
+	kind, opts := issuerListOptions(issuer)
+	var certificateRequests certManagerApi.CertificateRequestList
+	opts = append(opts, client.MatchingFields{certificateRequestIssuerRefField: issuerRefKey(kind, issuer.GetName())})
+	if err := r.List(ctx, &certificateRequests, opts...); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list CertificateRequests", "issuer", issuerRefKey(kind, issuer.GetName()))
+		return nil
 	}
 
-	requests := make([]reconcile.Request, len(attachedCertificates.Items))
-	for i, item := range attachedCertificates.Items {
-		requests[i] = reconcile.Request{
-			NamespacedName: types.NamespacedName{
-				Name:      item.GetName(),
-				Namespace: item.GetNamespace(),
-			},
+	var requests []reconcile.Request
+	for _, certificateRequest := range certificateRequests.Items {
+		if isPendingEstCertificateRequest(&certificateRequest) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&certificateRequest)})
 		}
 	}
 	return requests
@@ -17,16 +17,421 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"time"
+
+	apiutil "github.com/cert-manager/cert-manager/pkg/api/util"
+	certManagerApi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	cmmeta "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
 	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	certmanagerv1 "github.com/jquad-group/est-operator/api/v1"
 )
 
 var _ = Describe("CertManagerCertificateRequest Controller", func() {
 	Context("When reconciling a resource", func() {
+		const resourceName = "test-certificaterequest"
+		const issuerName = "test-certificaterequest-issuer"
+
+		ctx := context.Background()
+
+		typeNamespacedName := types.NamespacedName{
+			Name:      resourceName,
+			Namespace: "default",
+		}
+
+		var controllerReconciler *CertManagerCertificateRequestReconciler
+
+		BeforeEach(func() {
+			By("creating a ready EstIssuer")
+			issuer := &certmanagerv1.EstIssuer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      issuerName,
+					Namespace: "default",
+				},
+				Spec: certmanagerv1.EstIssuerSpec{
+					Hostname:       "est.example.com",
+					Port:           443,
+					AuthSecretName: "test-certificaterequest-credentials",
+				},
+			}
+			Expect(k8sClient.Create(ctx, issuer)).To(Succeed())
+			issuer.Status.Ready = true
+			Expect(k8sClient.Status().Update(ctx, issuer)).To(Succeed())
+
+			By("creating the CertificateRequest for the EstIssuer")
+			certificateRequest := &certManagerApi.CertificateRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      resourceName,
+					Namespace: "default",
+				},
+				Spec: certManagerApi.CertificateRequestSpec{
+					Request: newTestCertificateRequest("test-est.jquad.rocks"),
+					IssuerRef: cmmeta.ObjectReference{
+						Group: certmanagerv1.GroupVersion.Group,
+						Kind:  certmanagerv1.EstIssuerKind,
+						Name:  issuerName,
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, certificateRequest)).To(Succeed())
+
+			controllerReconciler = &CertManagerCertificateRequestReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+		})
+
+		AfterEach(func() {
+			By("Cleanup the CertificateRequest, its EstOrder and the EstIssuer")
+			Expect(k8sClient.Delete(ctx, &certManagerApi.CertificateRequest{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}})).To(Succeed())
+			// the test environment does not garbage collect the EstOrder
+			Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, &certmanagerv1.EstOrder{ObjectMeta: metav1.ObjectMeta{Name: resourceName, Namespace: "default"}}))).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &certmanagerv1.EstIssuer{ObjectMeta: metav1.ObjectMeta{Name: issuerName, Namespace: "default"}})).To(Succeed())
+		})
+
+		setCondition := func(conditionType certManagerApi.CertificateRequestConditionType, reason string) {
+			certificateRequest := &certManagerApi.CertificateRequest{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, certificateRequest)).To(Succeed())
+			apiutil.SetCertificateRequestCondition(certificateRequest, conditionType, cmmeta.ConditionTrue, reason, "")
+			Expect(k8sClient.Status().Update(ctx, certificateRequest)).To(Succeed())
+		}
+
+		reconcileRequest := func() *certManagerApi.CertificateRequest {
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			certificateRequest := &certManagerApi.CertificateRequest{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, certificateRequest)).To(Succeed())
+			return certificateRequest
+		}
+
+		readyCondition := func(certificateRequest *certManagerApi.CertificateRequest) *certManagerApi.CertificateRequestCondition {
+			return apiutil.GetCertificateRequestCondition(certificateRequest, certManagerApi.CertificateRequestConditionReady)
+		}
+
+		expectNoEstOrder := func() {
+			err := k8sClient.Get(ctx, typeNamespacedName, &certmanagerv1.EstOrder{})
+			Expect(errors.IsNotFound(err)).To(BeTrue(), "EstOrder must not exist")
+		}
+
+		It("should wait until the request is approved", func() {
+			certificateRequest := reconcileRequest()
+			Expect(readyCondition(certificateRequest)).To(BeNil())
+			expectNoEstOrder()
+		})
+
+		It("should fail a denied request without creating an EstOrder", func() {
+			setCondition(certManagerApi.CertificateRequestConditionDenied, "Denied")
+
+			certificateRequest := reconcileRequest()
+			ready := readyCondition(certificateRequest)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(cmmeta.ConditionFalse))
+			Expect(ready.Reason).To(Equal(certManagerApi.CertificateRequestReasonDenied))
+			Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+			Expect(apiutil.CertificateRequestIsDenied(certificateRequest)).To(BeTrue())
+			expectNoEstOrder()
+		})
+
+		It("should wait for the issuer to become ready", func() {
+			setCondition(certManagerApi.CertificateRequestConditionApproved, "Approved")
+			issuer := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: issuerName, Namespace: "default"}, issuer)).To(Succeed())
+			issuer.Status.Ready = false
+			Expect(k8sClient.Status().Update(ctx, issuer)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).To(MatchError(ContainSubstring("is not ready")))
+			expectNoEstOrder()
+		})
+
+		It("should create an EstOrder and propagate the issued certificate", func() {
+			setCondition(certManagerApi.CertificateRequestConditionApproved, "Approved")
+
+			By("Creating the EstOrder")
+			certificateRequest := reconcileRequest()
+			ready := readyCondition(certificateRequest)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Reason).To(Equal(certManagerApi.CertificateRequestReasonPending))
+			Expect(ready.Message).To(Equal("Created new EstOrder " + resourceName))
+
+			estOrder := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, estOrder)).To(Succeed())
+			Expect(estOrder.Spec.Request).To(Equal(certificateRequest.Spec.Request))
+			Expect(estOrder.Spec.IssuerRef.Kind).To(Equal(certmanagerv1.EstIssuerKind))
+			Expect(estOrder.Spec.IssuerRef.Name).To(Equal(issuerName))
+			Expect(estOrder.Spec.Renewal).To(BeFalse())
+			Expect(metav1.IsControlledBy(estOrder, certificateRequest)).To(BeTrue())
+
+			By("Propagating the phase of the EstOrder")
+			estOrder.Status.Phase = certmanagerv1.EstOrderPhaseSubmitted
+			Expect(k8sClient.Status().Update(ctx, estOrder)).To(Succeed())
+			certificateRequest = reconcileRequest()
+			Expect(readyCondition(certificateRequest).Message).To(Equal("EstOrder " + resourceName + " is Submitted"))
+
+			By("Propagating the issued certificate and the CA")
+			estServer := newFakeESTServer("estuser", "estpwd")
+			defer estServer.Close()
+			csr, err := decodeCertificateRequest(certificateRequest.Spec.Request)
+			Expect(err).NotTo(HaveOccurred())
+			leaf, err := estServer.sign(csr)
+			Expect(err).NotTo(HaveOccurred())
+			estOrder.Status.Phase = certmanagerv1.EstOrderPhaseIssued
+			estOrder.Status.Certificate = encodeCertificates([]*x509.Certificate{leaf})
+			estOrder.Status.Chain = encodeCertificates([]*x509.Certificate{estServer.caCert})
+			Expect(k8sClient.Status().Update(ctx, estOrder)).To(Succeed())
+
+			certificateRequest = reconcileRequest()
+			ready = readyCondition(certificateRequest)
+			Expect(ready.Status).To(Equal(cmmeta.ConditionTrue))
+			Expect(ready.Reason).To(Equal(certManagerApi.CertificateRequestReasonIssued))
+			Expect(certificateRequest.Status.Certificate).To(Equal(encodeCertificates([]*x509.Certificate{leaf})))
+			Expect(certificateRequest.Status.CA).To(Equal(encodeCertificates([]*x509.Certificate{estServer.caCert})))
+			Expect(apiutil.CertificateRequestIsApproved(certificateRequest)).To(BeTrue())
+		})
+
+		It("should fail a request for an unsupported issuer kind", func() {
+			certificateRequest := &certManagerApi.CertificateRequest{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-certificaterequest-unsupported-kind",
+					Namespace: "default",
+				},
+				Spec: certManagerApi.CertificateRequestSpec{
+					Request: newTestCertificateRequest("test-est.jquad.rocks"),
+					IssuerRef: cmmeta.ObjectReference{
+						Group: certmanagerv1.GroupVersion.Group,
+						Kind:  "Issuer",
+						Name:  issuerName,
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, certificateRequest)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, certificateRequest)).To(Succeed())
+			}()
+			apiutil.SetCertificateRequestCondition(certificateRequest, certManagerApi.CertificateRequestConditionApproved, cmmeta.ConditionTrue, "Approved", "")
+			Expect(k8sClient.Status().Update(ctx, certificateRequest)).To(Succeed())
+			Expect(isPendingEstCertificateRequest(certificateRequest)).To(BeTrue())
+
+			key := client.ObjectKeyFromObject(certificateRequest)
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: key})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, key, certificateRequest)).To(Succeed())
+			ready := readyCondition(certificateRequest)
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Reason).To(Equal(certManagerApi.CertificateRequestReasonFailed))
+			Expect(ready.Message).To(ContainSubstring(`issuer kind is not supported: "Issuer"`))
+			Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, key, &certmanagerv1.EstOrder{}))).To(BeTrue())
+		})
+
+		It("should create the EstOrder once and skip unchanged status patches", func() {
+			setCondition(certManagerApi.CertificateRequestConditionApproved, "Approved")
+			counter := &statusPatchCounter{Client: k8sClient}
+			controllerReconciler.Client = counter
+
+			By("Reconciling the request twice")
+			first := reconcileRequest()
+			Expect(counter.patches).To(Equal(1))
+			second := reconcileRequest()
+			Expect(counter.patches).To(Equal(1), "the unchanged status must not be patched again")
+			Expect(second.ResourceVersion).To(Equal(first.ResourceVersion))
+
+			var estOrders certmanagerv1.EstOrderList
+			Expect(k8sClient.List(ctx, &estOrders, client.InNamespace("default"))).To(Succeed())
+			var owned []certmanagerv1.EstOrder
+			for _, estOrder := range estOrders.Items {
+				if metav1.IsControlledBy(&estOrder, second) {
+					owned = append(owned, estOrder)
+				}
+			}
+			Expect(owned).To(HaveLen(1))
+			Expect(owned[0].Annotations).To(HaveKeyWithValue(certmanagerv1.EstOrderRequestHashAnnotation, certificateRequestHash(second.Spec.Request)))
+		})
+
+		It("should propagate the failure of the EstOrder", func() {
+			setCondition(certManagerApi.CertificateRequestConditionApproved, "Approved")
+			reconcileRequest()
+
+			estOrder := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, estOrder)).To(Succeed())
+			estOrder.Status.Phase = certmanagerv1.EstOrderPhaseDenied
+			estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+			estOrder.Status.FailureMessage = "Unauthorized: 401 Unauthorized"
+			Expect(k8sClient.Status().Update(ctx, estOrder)).To(Succeed())
+
+			certificateRequest := reconcileRequest()
+			ready := readyCondition(certificateRequest)
+			Expect(ready.Status).To(Equal(cmmeta.ConditionFalse))
+			Expect(ready.Reason).To(Equal(certManagerApi.CertificateRequestReasonFailed))
+			Expect(ready.Message).To(ContainSubstring("denied: Unauthorized: 401 Unauthorized"))
+			Expect(certificateRequest.Status.FailureTime).NotTo(BeNil())
+			Expect(certificateRequest.Status.Certificate).To(BeEmpty())
+		})
+	})
+
+	Context("When building the certificate chain of a completed EstOrder", func() {
+		It("should separate the root CA from the issued certificate", func() {
+			estServer := newFakeESTServer("estuser", "estpwd")
+			defer estServer.Close()
+
+			csr, err := decodeCertificateRequest(newTestCertificateRequest("test-est.jquad.rocks"))
+			Expect(err).NotTo(HaveOccurred())
+			leaf, err := estServer.sign(csr)
+			Expect(err).NotTo(HaveOccurred())
 
-		It("should successfully reconcile the resource", func() {
+			certificate, ca, err := buildCertificateChain(certmanagerv1.EstOrderStatus{
+				Certificate: encodeCertificates([]*x509.Certificate{leaf}),
+				Chain:       encodeCertificates([]*x509.Certificate{estServer.caCert}),
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(certificate).To(Equal(encodeCertificates([]*x509.Certificate{leaf})))
+			Expect(ca).To(Equal(encodeCertificates([]*x509.Certificate{estServer.caCert})))
+		})
+	})
+
+	Context("When filtering CertificateRequest events", func() {
+		newCertificateRequest := func(group, kind string) *certManagerApi.CertificateRequest {
+			certificateRequest := &certManagerApi.CertificateRequest{
+				Spec: certManagerApi.CertificateRequestSpec{
+					IssuerRef: cmmeta.ObjectReference{Group: group, Kind: kind, Name: "test-issuer"},
+				},
+			}
+			apiutil.SetCertificateRequestCondition(certificateRequest, certManagerApi.CertificateRequestConditionApproved, cmmeta.ConditionTrue, "Approved", "")
+			return certificateRequest
+		}
+
+		It("should accept approved requests for EST issuers", func() {
+			Expect(isPendingEstCertificateRequest(newCertificateRequest(certmanagerv1.GroupVersion.Group, certmanagerv1.EstIssuerKind))).To(BeTrue())
+			Expect(isPendingEstCertificateRequest(newCertificateRequest(certmanagerv1.GroupVersion.Group, certmanagerv1.ClusterEstIssuerKind))).To(BeTrue())
+		})
+		It("should skip requests for issuers of other groups", func() {
+			Expect(isPendingEstCertificateRequest(newCertificateRequest("cert-manager.io", "ClusterIssuer"))).To(BeFalse())
+		})
+		It("should accept requests for other kinds of the group, so they are marked as failed", func() {
+			Expect(isPendingEstCertificateRequest(newCertificateRequest(certmanagerv1.GroupVersion.Group, "Issuer"))).To(BeTrue())
+		})
+		It("should skip requests which are not approved", func() {
+			certificateRequest := newCertificateRequest(certmanagerv1.GroupVersion.Group, certmanagerv1.EstIssuerKind)
+			certificateRequest.Status.Conditions = nil
+			Expect(isPendingEstCertificateRequest(certificateRequest)).To(BeFalse())
+		})
+		It("should accept denied requests, so they are marked as failed", func() {
+			certificateRequest := newCertificateRequest(certmanagerv1.GroupVersion.Group, certmanagerv1.EstIssuerKind)
+			certificateRequest.Status.Conditions = nil
+			apiutil.SetCertificateRequestCondition(certificateRequest, certManagerApi.CertificateRequestConditionDenied, cmmeta.ConditionTrue, "Denied", "")
+			Expect(isPendingEstCertificateRequest(certificateRequest)).To(BeTrue())
+		})
+		It("should skip requests which are completed", func() {
+			for _, reason := range []string{
+				certManagerApi.CertificateRequestReasonIssued,
+				certManagerApi.CertificateRequestReasonFailed,
+				certManagerApi.CertificateRequestReasonDenied,
+			} {
+				certificateRequest := newCertificateRequest(certmanagerv1.GroupVersion.Group, certmanagerv1.EstIssuerKind)
+				apiutil.SetCertificateRequestCondition(certificateRequest, certManagerApi.CertificateRequestConditionReady, cmmeta.ConditionFalse, reason, "")
+				Expect(isPendingEstCertificateRequest(certificateRequest)).To(BeFalse(), reason)
+			}
+		})
+	})
 
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+	Context("When keying the EstOrder of a CertificateRequest", func() {
+		It("should identify a request by its digest", func() {
+			request := newTestCertificateRequest("test-est.jquad.rocks")
+			Expect(certificateRequestHash(request)).To(HaveLen(64))
+			Expect(certificateRequestHash(request)).To(Equal(certificateRequestHash(bytes.Clone(request))))
+			Expect(certificateRequestHash(request)).NotTo(Equal(certificateRequestHash(newTestCertificateRequest("test-est.jquad.rocks"))))
+		})
+	})
+
+	Context("When filtering issuer events", func() {
+		newIssuer := func(ready bool) *certmanagerv1.EstIssuer {
+			return &certmanagerv1.EstIssuer{Status: certmanagerv1.EstIssuerStatus{Ready: ready}}
+		}
+
+		It("should retry waiting requests when the issuer becomes ready", func() {
+			Expect(issuerBecameReady().Update(event.UpdateEvent{ObjectOld: newIssuer(false), ObjectNew: newIssuer(true)})).To(BeTrue())
+			Expect(issuerBecameReady().Update(event.UpdateEvent{
+				ObjectOld: &certmanagerv1.ClusterEstIssuer{},
+				ObjectNew: &certmanagerv1.ClusterEstIssuer{Status: certmanagerv1.EstIssuerStatus{Ready: true}},
+			})).To(BeTrue())
+		})
+		It("should ignore issuers whose readiness does not change to ready", func() {
+			Expect(issuerBecameReady().Update(event.UpdateEvent{ObjectOld: newIssuer(true), ObjectNew: newIssuer(true)})).To(BeFalse())
+			Expect(issuerBecameReady().Update(event.UpdateEvent{ObjectOld: newIssuer(true), ObjectNew: newIssuer(false)})).To(BeFalse())
+			Expect(issuerBecameReady().Create(event.CreateEvent{Object: newIssuer(true)})).To(BeFalse())
+		})
+		It("should list the objects referring to an issuer in its scope", func() {
+			kind, opts := issuerListOptions(&certmanagerv1.EstIssuer{ObjectMeta: metav1.ObjectMeta{Name: "test-issuer", Namespace: "default"}})
+			Expect(kind).To(Equal(certmanagerv1.EstIssuerKind))
+			Expect(opts).To(ConsistOf(client.InNamespace("default")))
+
+			kind, opts = issuerListOptions(&certmanagerv1.ClusterEstIssuer{ObjectMeta: metav1.ObjectMeta{Name: "test-issuer"}})
+			Expect(kind).To(Equal(certmanagerv1.ClusterEstIssuerKind))
+			Expect(opts).To(BeEmpty())
+		})
+	})
+
+	Context("When validating a request against the CSR attributes of the issuer", func() {
+		request := newTestCertificateRequest("test-est.jquad.rocks")
+
+		It("should accept requests without CSR attributes", func() {
+			Expect(validateCertificateRequestAttributes(request, nil)).To(Succeed())
+		})
+		It("should accept requests satisfying the CSR attributes", func() {
+			Expect(validateCertificateRequestAttributes(request, &certmanagerv1.CSRAttributes{
+				OIDs:               []string{"1.2.840.10045.4.3.2"},
+				RequiredExtensions: []string{"2.5.29.17"},
+				KeyType:            "ECDSA",
+				KeyCurve:           "1.2.840.10045.3.1.7",
+			})).To(Succeed())
+		})
+		It("should report every attribute the request violates", func() {
+			err := validateCertificateRequestAttributes(request, &certmanagerv1.CSRAttributes{
+				OIDs:               []string{"1.2.840.113549.1.1.11"},
+				RequiredExtensions: []string{"2.5.29.15"},
+				KeyType:            "RSA",
+			})
+			Expect(err).To(MatchError(ContainSubstring("missing extension 2.5.29.15")))
+			Expect(err).To(MatchError(ContainSubstring("expected RSA")))
+			Expect(err).To(MatchError(ContainSubstring("signature algorithm")))
+		})
+		It("should reject requests with a key on another curve", func() {
+			err := validateCertificateRequestAttributes(request, &certmanagerv1.CSRAttributes{
+				KeyType:  "ECDSA",
+				KeyCurve: "1.3.132.0.34",
+			})
+			Expect(err).To(MatchError(ContainSubstring("expected P-384")))
 		})
 	})
 })
+
+// statusPatchCounter counts the status patches made through the client.
+type statusPatchCounter struct {
+	client.Client
+	patches int
+}
+
+func (c *statusPatchCounter) Status() client.SubResourceWriter {
+	return &countingStatusWriter{SubResourceWriter: c.Client.Status(), counter: c}
+}
+
+type countingStatusWriter struct {
+	client.SubResourceWriter
+	counter *statusPatchCounter
+}
+
+func (w *countingStatusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	w.counter.patches++
+	return w.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+}
@@ -19,10 +19,16 @@ package controller
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	certmanagerv1 "github.com/jquad-group/est-operator/api/v1"
 )
@@ -30,33 +36,80 @@ import (
 // ClusterEstIssuerReconciler reconciles a ClusterEstIssuer object
 type ClusterEstIssuerReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// ClusterResourceNamespace is the namespace in which the Secrets referenced by ClusterEstIssuers are looked up.
+	ClusterResourceNamespace string
 }
 
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=clusterestissuers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=clusterestissuers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=clusterestissuers/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the ClusterEstIssuer object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// A ClusterEstIssuer is validated like an EstIssuer, except that its Secrets are
+// looked up in the cluster resource namespace.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.2/pkg/reconcile
 func (r *ClusterEstIssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	log := log.FromContext(ctx)
 
-	// TODO(user): your logic here
+	// Fetch the ClusterESTIssuer resource
+	var issuer certmanagerv1.ClusterEstIssuer
+	if err := r.Get(ctx, req.NamespacedName, &issuer); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
 
-	return ctrl.Result{}, nil
+	result, err := reconcileIssuer(ctx, r.Client, r.Recorder, &issuer, issuer.GetSecretNamespace(r.ClusterResourceNamespace), "clusterestissuer-controller")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Reconciled ClusterESTIssuer", "name", req.Name, "ready", issuer.Status.Ready)
+	return result, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the controller with the Manager. Only changes of the spec trigger a validation, the
+// status updates of a validation do not, so that failed validations are retried with their backoff.
 func (r *ClusterEstIssuerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := indexIssuerReferences(mgr.GetFieldIndexer(), &certmanagerv1.ClusterEstIssuer{}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&certmanagerv1.ClusterEstIssuer{}).
+		For(&certmanagerv1.ClusterEstIssuer{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.findIssuersReferencing(issuerSecretNamesField)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+		).
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.findIssuersReferencing(issuerConfigMapNamesField)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+		).
 		Complete(r)
 }
+
+// findIssuersReferencing maps a Secret or ConfigMap of the cluster resource namespace to the ClusterEstIssuers
+// referencing it in the given field index, so that rotated credentials and trust anchors are validated again.
+func (r *ClusterEstIssuerReconciler) findIssuersReferencing(field string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		issuers, err := findClusterEstIssuersReferencing(ctx, r.Client, obj, field, r.ClusterResourceNamespace)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed to list ClusterEstIssuers", "field", field, "name", obj.GetName())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, len(issuers))
+		for i, issuer := range issuers {
+			requests[i] = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&issuer)}
+		}
+		return requests
+	}
+}
@@ -21,8 +21,11 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,52 +36,100 @@ import (
 var _ = Describe("ClusterEstIssuer Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
+		const secretName = "test-clusterestissuer-credentials"
+		const clusterResourceNamespace = "default"
 
 		ctx := context.Background()
 
 		typeNamespacedName := types.NamespacedName{
-			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
+			Name: resourceName,
 		}
 		clusterestissuer := &certmanagerv1.ClusterEstIssuer{}
 
+		var estServer *fakeESTServer
+
 		BeforeEach(func() {
+			estServer = newFakeESTServer("estuser", "estpwd")
+
+			By("creating the credentials in the cluster resource namespace")
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      secretName,
+					Namespace: clusterResourceNamespace,
+				},
+				Type: corev1.SecretTypeBasicAuth,
+				Data: map[string][]byte{
+					corev1.BasicAuthUsernameKey: []byte("estuser"),
+					corev1.BasicAuthPasswordKey: []byte("estpwd"),
+				},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
 			By("creating the custom resource for the Kind ClusterEstIssuer")
 			err := k8sClient.Get(ctx, typeNamespacedName, clusterestissuer)
 			if err != nil && errors.IsNotFound(err) {
 				resource := &certmanagerv1.ClusterEstIssuer{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      resourceName,
-						Namespace: "default",
+						Name: resourceName,
+					},
+					Spec: certmanagerv1.EstIssuerSpec{
+						Hostname:       estServer.hostname(),
+						Port:           estServer.port(),
+						Cacert:         estServer.trustAnchor(),
+						AuthSecretName: secretName,
 					},
-					// TODO(user): Specify other spec details if needed.
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
 		})
 
 		AfterEach(func() {
-			// TODO(user): Cleanup logic after each test, like removing the resource instance.
 			resource := &certmanagerv1.ClusterEstIssuer{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
 			By("Cleanup the specific resource instance ClusterEstIssuer")
 			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: clusterResourceNamespace}})).To(Succeed())
+			estServer.Close()
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &ClusterEstIssuerReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:                   k8sClient,
+				Scheme:                   k8sClient.Scheme(),
+				Recorder:                 record.NewFakeRecorder(10),
+				ClusterResourceNamespace: clusterResourceNamespace,
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			resource := &certmanagerv1.ClusterEstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, certmanagerv1.IssuerConditionReady)).To(BeTrue())
+		})
+		It("should not be ready when the credentials are missing from the cluster resource namespace", func() {
+			controllerReconciler := &ClusterEstIssuerReconciler{
+				Client:                   k8sClient,
+				Scheme:                   k8sClient.Scheme(),
+				Recorder:                 record.NewFakeRecorder(10),
+				ClusterResourceNamespace: "kube-system",
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.ClusterEstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeFalse())
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, certmanagerv1.IssuerConditionReady)).To(BeTrue())
+			Expect(meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.IssuerConditionReady).Message).To(ContainSubstring("Referenced secret not found"))
 		})
 	})
 })
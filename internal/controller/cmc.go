@@ -0,0 +1,334 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+
+	certmanagerv1 "github.com/jquad-group/est-operator/api/v1"
+)
+
+const (
+	fullCMCPath = "fullcmc"
+	// cmcRequestBodyPartID identifies the certification request within a Full CMC request.
+	cmcRequestBodyPartID = 1
+)
+
+var (
+	oidCMCPKIData      = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 12, 2}
+	oidCMCPKIResponse  = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 12, 3}
+	oidCMCStatusInfo   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 7, 1}
+	oidCMCStatusInfoV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 7, 25}
+	oidCMCQueryPending = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 7, 21}
+)
+
+// CMC status values as defined in RFC 5272 Sec. 6.1.1.
+const (
+	cmcStatusSuccess = 0
+	cmcStatusFailed  = 2
+	cmcStatusPending = 3
+)
+
+var cmcStatusNames = map[int]string{
+	0: "success",
+	2: "failed",
+	3: "pending",
+	4: "noSupport",
+	5: "confirmRequired",
+	6: "popRequired",
+	7: "partial",
+}
+
+// CMC failure reasons as defined in RFC 5272 Sec. 6.1.4.
+var cmcFailInfoNames = map[int]string{
+	0:  "badAlg",
+	1:  "badMessageCheck",
+	2:  "badRequest",
+	3:  "badTime",
+	4:  "badCertId",
+	5:  "unsupportedExt",
+	6:  "mustArchiveKeys",
+	7:  "badIdentity",
+	8:  "popRequired",
+	9:  "popFailed",
+	10: "noKeyReuse",
+	11: "internalCAError",
+	12: "tryLater",
+	13: "authDataFail",
+}
+
+// cmcTaggedAttribute is a control of a CMC message (RFC 5272 Sec. 3.2.1.1).
+type cmcTaggedAttribute struct {
+	BodyPartID int
+	AttrType   asn1.ObjectIdentifier
+	AttrValues []asn1.RawValue `asn1:"set"`
+}
+
+// cmcTaggedCertificationRequest is a PKCS#10 request within a CMC message (RFC 5272 Sec. 3.2.1.2.1).
+type cmcTaggedCertificationRequest struct {
+	BodyPartID           int
+	CertificationRequest asn1.RawValue
+}
+
+// cmcPKIData is the content of a Full PKI Request (RFC 5272 Sec. 3.2).
+type cmcPKIData struct {
+	ControlSequence  []cmcTaggedAttribute
+	ReqSequence      []asn1.RawValue
+	CMSSequence      []asn1.RawValue
+	OtherMsgSequence []asn1.RawValue
+}
+
+// cmcPKIResponse is the content of a Full PKI Response (RFC 5272 Sec. 4.2).
+type cmcPKIResponse struct {
+	ControlSequence  []cmcTaggedAttribute
+	CMSSequence      []asn1.RawValue
+	OtherMsgSequence []asn1.RawValue
+}
+
+// cmcStatusInfo is the value of a CMC Status Info control. Both versions of the control
+// (RFC 5272 Sec. 6.1.1 and 6.1.2) share this structure as far as it is interpreted here.
+type cmcStatusInfo struct {
+	Status          int
+	BodyList        []asn1.RawValue
+	StatusString    string        `asn1:"optional,utf8"`
+	OtherStatusInfo asn1.RawValue `asn1:"optional"`
+}
+
+// cmcPendInfo identifies a pending request and the time it should be polled at.
+type cmcPendInfo struct {
+	PendToken []byte
+	PendTime  time.Time `asn1:"generalized"`
+}
+
+// cmcExtendedFailInfo is the ExtendedFailInfo of a failed CMC request (RFC 5272 Sec. 6.1.1), a failure
+// reason defined outside of CMCFailInfo.
+type cmcExtendedFailInfo struct {
+	FailInfoOID   asn1.ObjectIdentifier
+	FailInfoValue asn1.RawValue
+}
+
+// cmcResponse is the interpreted Full PKI Response of the EST portal.
+type cmcResponse struct {
+	Certificates []*x509.Certificate
+	Status       certmanagerv1.CMCStatus
+	PendTime     time.Time
+	statusCode   int
+}
+
+// buildFullCMCRequest wraps the PKCS#10 request into a Full PKI Request signed by the RA, as described
+// in RFC 7030 Sec. 4.3.1. The RA vouches for the requester, which proves possession of its key by
+// the signature of the PKCS#10 request itself.
+func buildFullCMCRequest(csr *x509.CertificateRequest, ra tls.Certificate) ([]byte, error) {
+	tcr, err := asn1.Marshal(cmcTaggedCertificationRequest{
+		BodyPartID:           cmcRequestBodyPartID,
+		CertificationRequest: asn1.RawValue{FullBytes: csr.Raw},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the TaggedRequest CHOICE is implicitly tagged, the tag replaces the one of the SEQUENCE
+	var request asn1.RawValue
+	if _, err := asn1.Unmarshal(tcr, &request); err != nil {
+		return nil, err
+	}
+	return signCMCData(cmcPKIData{
+		ControlSequence: []cmcTaggedAttribute{},
+		ReqSequence: []asn1.RawValue{{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      request.Bytes,
+		}},
+		CMSSequence:      []asn1.RawValue{},
+		OtherMsgSequence: []asn1.RawValue{},
+	}, ra)
+}
+
+// buildCMCQueryPending creates a Full PKI Request signed by the RA, which polls the pending request
+// identified by the token as described in RFC 5272 Sec. 6.13.
+func buildCMCQueryPending(pendToken []byte, ra tls.Certificate) ([]byte, error) {
+	token, err := asn1.Marshal(pendToken)
+	if err != nil {
+		return nil, err
+	}
+	return signCMCData(cmcPKIData{
+		ControlSequence: []cmcTaggedAttribute{{
+			BodyPartID: cmcRequestBodyPartID,
+			AttrType:   oidCMCQueryPending,
+			AttrValues: []asn1.RawValue{{FullBytes: token}},
+		}},
+		ReqSequence:      []asn1.RawValue{},
+		CMSSequence:      []asn1.RawValue{},
+		OtherMsgSequence: []asn1.RawValue{},
+	}, ra)
+}
+
+// signCMCData encapsulates the PKIData in a SignedData structure signed with the key of the RA.
+func signCMCData(data cmcPKIData, ra tls.Certificate) ([]byte, error) {
+	content, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode CMC request: %w", err)
+	}
+
+	signedData, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	// the content type is signed as an attribute, so it has to be set before the signer is added
+	signedData.GetSignedData().ContentInfo.ContentType = oidCMCPKIData
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signedData.AddSigner(ra.Leaf, ra.PrivateKey, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("unable to sign CMC request: %w", err)
+	}
+	return signedData.Finish()
+}
+
+// isCMCResponse reports whether the EST portal answered with a CMC response (RFC 7030 Sec. 4.3.2).
+func isCMCResponse(resp *http.Response) bool {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != mimeTypePKCS7 {
+		return false
+	}
+	smimeType, ok := params["smime-type"]
+	return !ok || strings.EqualFold(smimeType, "CMC-response")
+}
+
+// parseFullCMCResponse interprets the response to a Full CMC request. A Simple PKI Response only holds the
+// issued certificates, a Full PKI Response carries the status of the request in its controls, and is
+// verified against the certificates it contains.
+func parseFullCMCResponse(der []byte) (*cmcResponse, error) {
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PKCS#7 structure: %w", err)
+	}
+
+	result := &cmcResponse{Certificates: p7.Certificates}
+	if len(p7.Signers) == 0 {
+		// a Simple PKI Response is only sent for issued certificates
+		result.setStatus(cmcStatusSuccess)
+		return result, nil
+	}
+	if err := p7.Verify(); err != nil {
+		return nil, fmt.Errorf("invalid signature of the CMC response: %w", err)
+	}
+
+	var pkiResponse cmcPKIResponse
+	if rest, err := asn1.Unmarshal(p7.Content, &pkiResponse); err != nil {
+		return nil, fmt.Errorf("malformed PKIResponse: %w", err)
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after PKIResponse")
+	}
+
+	for _, control := range pkiResponse.ControlSequence {
+		if !control.AttrType.Equal(oidCMCStatusInfoV2) && !control.AttrType.Equal(oidCMCStatusInfo) {
+			continue
+		}
+		for _, value := range control.AttrValues {
+			var info cmcStatusInfo
+			if _, err := asn1.Unmarshal(value.FullBytes, &info); err != nil {
+				return nil, fmt.Errorf("malformed CMC status info: %w", err)
+			}
+			result.setStatus(info.Status)
+			result.Status.StatusString = info.StatusString
+			if err := result.setOtherStatusInfo(info.OtherStatusInfo); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+	}
+
+	// RFC 5272 Sec. 4.2 treats a Full PKI Response without status as a success if it contains certificates
+	if len(result.Certificates) == 0 {
+		return nil, errors.New("CMC response contains neither a status nor certificates")
+	}
+	result.setStatus(cmcStatusSuccess)
+	return result, nil
+}
+
+// setStatus sets the CMC status code together with its name.
+func (r *cmcResponse) setStatus(code int) {
+	r.statusCode = code
+	r.Status.Status = cmcStatusNames[code]
+	if r.Status.Status == "" {
+		r.Status.Status = strconv.Itoa(code)
+	}
+}
+
+// setOtherStatusInfo interprets the failure reason or the pending information of a CMC status.
+func (r *cmcResponse) setOtherStatusInfo(value asn1.RawValue) error {
+	switch {
+	case len(value.FullBytes) == 0:
+		return nil
+	case value.Class == asn1.ClassUniversal && value.Tag == asn1.TagInteger:
+		var failInfo int
+		if _, err := asn1.Unmarshal(value.FullBytes, &failInfo); err != nil {
+			return fmt.Errorf("malformed CMC failure info: %w", err)
+		}
+		r.Status.FailInfo = cmcFailInfoNames[failInfo]
+		if r.Status.FailInfo == "" {
+			r.Status.FailInfo = strconv.Itoa(failInfo)
+		}
+	case value.Class == asn1.ClassUniversal && value.Tag == asn1.TagSequence:
+		// the pending info starts with the pending token, an extended failure info with the OID of the failure
+		var sequence, first asn1.RawValue
+		if _, err := asn1.Unmarshal(value.FullBytes, &sequence); err != nil {
+			return fmt.Errorf("malformed CMC status info: %w", err)
+		}
+		if _, err := asn1.Unmarshal(sequence.Bytes, &first); err != nil {
+			return fmt.Errorf("malformed CMC status info: %w", err)
+		}
+		if first.Class == asn1.ClassUniversal && first.Tag == asn1.TagOID {
+			var extendedFailInfo cmcExtendedFailInfo
+			if _, err := asn1.Unmarshal(value.FullBytes, &extendedFailInfo); err != nil {
+				return fmt.Errorf("malformed CMC extended failure info: %w", err)
+			}
+			r.Status.FailInfo = extendedFailInfo.FailInfoOID.String()
+			return nil
+		}
+		var pendInfo cmcPendInfo
+		if _, err := asn1.Unmarshal(value.FullBytes, &pendInfo); err != nil {
+			return fmt.Errorf("malformed CMC pending info: %w", err)
+		}
+		r.Status.PendToken = pendInfo.PendToken
+		r.PendTime = pendInfo.PendTime
+	}
+	return nil
+}
+
+// message describes the CMC status of an unsuccessful request.
+func (r *cmcResponse) message() string {
+	message := fmt.Sprintf("CMC status %s", r.Status.Status)
+	if r.Status.FailInfo != "" {
+		message += fmt.Sprintf(" (%s)", r.Status.FailInfo)
+	}
+	if r.Status.StatusString != "" {
+		message += ": " + r.Status.StatusString
+	}
+	return message
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// initialEstOrderBackoff is the delay before a request which failed for a retryable reason is submitted again.
+	initialEstOrderBackoff = 30 * time.Second
+	// maxEstOrderBackoff caps the exponential backoff of failed requests.
+	maxEstOrderBackoff = 30 * time.Minute
+)
+
+// estErrorReason classifies why an EST request failed.
+type estErrorReason string
+
+const (
+	// estErrorBadRequest means the EST portal rejected the request as malformed, e.g. an invalid CSR (400).
+	estErrorBadRequest estErrorReason = "BadRequest"
+	// estErrorUnauthorized means the EST portal did not accept the credentials of the issuer (401, 403).
+	estErrorUnauthorized estErrorReason = "Unauthorized"
+	// estErrorNotFound means the EST operation does not exist, which usually is a wrong label of the issuer (404).
+	estErrorNotFound estErrorReason = "NotFound"
+	// estErrorPolicyViolation means the request violates a policy of the CA (409, 412).
+	estErrorPolicyViolation estErrorReason = "PolicyViolation"
+	// estErrorRejected means the EST portal rejected the request with another client error.
+	estErrorRejected estErrorReason = "Rejected"
+	// estErrorThrottled means the EST portal is overloaded or unavailable and asks to retry later (429, 503).
+	estErrorThrottled estErrorReason = "Throttled"
+	// estErrorServerError means the EST portal failed to process the request (5xx).
+	estErrorServerError estErrorReason = "ServerError"
+	// estErrorTimeout means the EST portal did not answer in time (408, or a timeout of the connection).
+	estErrorTimeout estErrorReason = "Timeout"
+	// estErrorTLSVerification means the TLS certificate of the EST portal is not trusted by the issuer.
+	estErrorTLSVerification estErrorReason = "TLSVerificationFailed"
+	// estErrorConnection means the EST portal could not be reached.
+	estErrorConnection estErrorReason = "ConnectionFailed"
+	// estErrorUnexpectedResponse means the EST portal answered with a status code EST does not define.
+	estErrorUnexpectedResponse estErrorReason = "UnexpectedResponse"
+)
+
+// estError is a failed EST request. Failures caused by the request itself or by the configuration of the
+// issuer are terminal, submitting the same request again would not change the outcome. Failures of the
+// EST portal or the network are retried.
+type estError struct {
+	reason estErrorReason
+	// statusCode is the HTTP status code of the response, zero if no response was received.
+	statusCode int
+	// message is the error message, including the text the EST portal sent along with the response.
+	message string
+	// retryAfter is the delay the EST portal asked for in the Retry-After header, zero if absent.
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *estError) Error() string {
+	return fmt.Sprintf("%s: %s", e.reason, e.message)
+}
+
+func (e *estError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether the request may succeed when it is submitted again.
+func (e *estError) retryable() bool {
+	switch e.reason {
+	case estErrorThrottled, estErrorServerError, estErrorTimeout, estErrorConnection, estErrorUnexpectedResponse:
+		return true
+	}
+	return false
+}
+
+// denied reports whether the EST portal denied the request, rather than the request failing.
+func (e *estError) denied() bool {
+	return e.reason == estErrorUnauthorized || e.reason == estErrorPolicyViolation
+}
+
+// newESTResponseError classifies an unsuccessful response of the EST portal. The text of the response,
+// which RFC 7030 Sec. 4.2.3 allows the portal to explain the error with, is included in the message.
+func newESTResponseError(resp *http.Response, now time.Time) *estError {
+	estErr := &estError{
+		statusCode: resp.StatusCode,
+		message:    readErrorResponse(resp),
+	}
+	switch code := resp.StatusCode; {
+	case code == http.StatusBadRequest:
+		estErr.reason = estErrorBadRequest
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		estErr.reason = estErrorUnauthorized
+	case code == http.StatusNotFound:
+		estErr.reason = estErrorNotFound
+		estErr.message += " (check the label of the issuer)"
+	case code == http.StatusConflict || code == http.StatusPreconditionFailed:
+		estErr.reason = estErrorPolicyViolation
+	case code == http.StatusRequestTimeout:
+		estErr.reason = estErrorTimeout
+	case code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable:
+		estErr.reason = estErrorThrottled
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			estErr.retryAfter = retryAfter
+		}
+	case code >= 400 && code < 500:
+		estErr.reason = estErrorRejected
+	case code >= 500:
+		estErr.reason = estErrorServerError
+	default:
+		estErr.reason = estErrorUnexpectedResponse
+	}
+	return estErr
+}
+
+// newESTTransportError classifies a request to the EST portal which did not receive a response.
+func newESTTransportError(err error) *estError {
+	estErr := &estError{
+		reason:  estErrorConnection,
+		message: err.Error(),
+		err:     err,
+	}
+
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &verificationErr), errors.As(err, &unknownAuthorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		estErr.reason = estErrorTLSVerification
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		estErr.reason = estErrorTimeout
+	}
+	return estErr
+}
+
+// estOrderBackoff returns the delay before a request is submitted again, which doubles with every
+// retryable failure in a row up to maxEstOrderBackoff.
+func estOrderBackoff(failures int32) time.Duration {
+	backoff := initialEstOrderBackoff
+	for i := int32(1); i < failures && backoff < maxEstOrderBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxEstOrderBackoff {
+		return maxEstOrderBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,500 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/est"
+	"go.mozilla.org/pkcs7"
+)
+
+// fakeESTServer is a minimal EST portal backed by a throwaway CA.
+type fakeESTServer struct {
+	*httptest.Server
+	caCert   *x509.Certificate
+	caKey    crypto.Signer
+	username string
+	password string
+	// retryAfter makes the server defer enrollments with 202 Accepted and the given Retry-After header.
+	retryAfter string
+	// failStatus makes the server answer enrollments with the given HTTP status code and failMessage as text.
+	failStatus  int
+	failMessage string
+	// issueForeignKey makes the server answer enrollments with a certificate for a key other than the requested one.
+	issueForeignKey bool
+	// requireClientCert makes the server only accept enrollments authenticated with a client certificate issued by its CA.
+	requireClientCert bool
+	// keyEncryptionCert makes the server encrypt generated private keys for the given RSA certificate.
+	keyEncryptionCert *x509.Certificate
+	// csrAttrs are served by the /csrattrs endpoint, which answers 404 Not Found if unset.
+	csrAttrs *est.CSRAttrs
+	// authenticateCSRAttrs makes the /csrattrs endpoint require the credentials of the server.
+	authenticateCSRAttrs bool
+	// cmcFailInfo makes the server reject Full CMC requests with the given CMC failure reason.
+	cmcFailInfo *int
+}
+
+func newFakeESTServer(username, password string) *fakeESTServer {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake EST Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		panic(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &fakeESTServer{caCert: caCert, caKey: caKey, username: username, password: password}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/est/cacerts", s.handleCACerts)
+	mux.HandleFunc("/.well-known/est/csrattrs", s.handleCSRAttrs)
+	mux.HandleFunc("/.well-known/est/simpleenroll", s.handleEnroll)
+	mux.HandleFunc("/.well-known/est/simplereenroll", s.handleReenroll)
+	mux.HandleFunc("/.well-known/est/serverkeygen", s.handleServerKeyGen)
+	mux.HandleFunc("/.well-known/est/fullcmc", s.handleFullCMC)
+	s.Server = httptest.NewUnstartedServer(mux)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	s.TLS = &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  clientCAs,
+	}
+	s.StartTLS()
+	return s
+}
+
+func (s *fakeESTServer) hostname() string {
+	host, _, _ := net.SplitHostPort(s.Listener.Addr().String())
+	return host
+}
+
+func (s *fakeESTServer) port() int {
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// trustAnchor returns the TLS certificate of the server in the format of EstIssuerSpec.Cacert.
+func (s *fakeESTServer) trustAnchor() string {
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw}))
+}
+
+// caPEM returns the CA certificate of the server in PEM encoding.
+func (s *fakeESTServer) caPEM() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw}))
+}
+
+func (s *fakeESTServer) handleCACerts(w http.ResponseWriter, _ *http.Request) {
+	s.writeCerts(w, s.caCert.Raw)
+}
+
+func (s *fakeESTServer) handleCSRAttrs(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); s.authenticateCSRAttrs && (!ok || username != s.username || password != s.password) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.csrAttrs == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	der, err := s.csrAttrs.Marshal()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/csrattrs")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(der)))
+}
+
+func (s *fakeESTServer) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	if s.requireClientCert && len(r.TLS.VerifiedChains) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if username, password, ok := r.BasicAuth(); !s.requireClientCert && (!ok || username != s.username || password != s.password) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.enroll(w, r)
+}
+
+// handleReenroll only accepts requests authenticated with a certificate issued by the CA of the server.
+func (s *fakeESTServer) handleReenroll(w http.ResponseWriter, r *http.Request) {
+	if len(r.TLS.VerifiedChains) == 0 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.enroll(w, r)
+}
+
+func (s *fakeESTServer) enroll(w http.ResponseWriter, r *http.Request) {
+	if s.failStatus != 0 {
+		if s.retryAfter != "" {
+			w.Header().Set("Retry-After", s.retryAfter)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(s.failStatus)
+		_, _ = w.Write([]byte(s.failMessage))
+		return
+	}
+	if s.retryAfter != "" {
+		w.Header().Set("Retry-After", s.retryAfter)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.issueForeignKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		csr.PublicKey = key.Public()
+	}
+
+	cert, err := s.sign(csr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.writeCerts(w, s.caCert.Raw, cert.Raw)
+}
+
+// handleServerKeyGen issues a certificate for a key generated by the server, which is returned in the clear
+// or encrypted for keyEncryptionCert.
+func (s *fakeESTServer) handleServerKeyGen(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != s.username || password != s.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	csr.PublicKey = key.Public()
+	cert, err := s.sign(csr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	keyType := "application/pkcs8"
+	if s.keyEncryptionCert != nil {
+		if keyDER, err = pkcs7.Encrypt(keyDER, []*x509.Certificate{s.keyEncryptionCert}); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		keyType = "application/pkcs7-mime; smime-type=server-generated-key"
+	}
+	p7, err := pkcs7.DegenerateCertificate(bytes.Join([][]byte{s.caCert.Raw, cert.Raw}, nil))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var response bytes.Buffer
+	parts := multipart.NewWriter(&response)
+	for _, part := range []struct {
+		contentType string
+		der         []byte
+	}{
+		{keyType, keyDER},
+		{"application/pkcs7-mime; smime-type=certs-only", p7},
+	} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = writer.Write([]byte(base64.StdEncoding.EncodeToString(part.der)))
+	}
+	_ = parts.Close()
+
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+parts.Boundary())
+	_, _ = w.Write(response.Bytes())
+}
+
+// handleFullCMC issues a certificate for the request of a Full PKI Request, which must be signed by an RA
+// certificate issued by the CA of the server.
+func (s *fakeESTServer) handleFullCMC(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != s.username || password != s.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	der, err := base64.StdEncoding.DecodeString(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	trustStore := x509.NewCertPool()
+	trustStore.AddCert(s.caCert)
+	if err := p7.VerifyWithChain(trustStore); err != nil {
+		s.writeCMCResponse(w, cmcStatusFailed, 7)
+		return
+	}
+	if s.cmcFailInfo != nil {
+		s.writeCMCResponse(w, cmcStatusFailed, *s.cmcFailInfo)
+		return
+	}
+
+	var pkiData cmcPKIData
+	if _, err := asn1.Unmarshal(p7.Content, &pkiData); err != nil || len(pkiData.ReqSequence) != 1 || pkiData.ReqSequence[0].Tag != 0 {
+		s.writeCMCResponse(w, cmcStatusFailed, 2)
+		return
+	}
+	var tcr cmcTaggedCertificationRequest
+	request := pkiData.ReqSequence[0]
+	request.Class, request.Tag, request.FullBytes = asn1.ClassUniversal, asn1.TagSequence, nil
+	requestDER, _ := asn1.Marshal(request)
+	if _, err := asn1.Unmarshal(requestDER, &tcr); err != nil {
+		s.writeCMCResponse(w, cmcStatusFailed, 2)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(tcr.CertificationRequest.FullBytes)
+	if err != nil || csr.CheckSignature() != nil {
+		s.writeCMCResponse(w, cmcStatusFailed, 9)
+		return
+	}
+
+	cert, err := s.sign(csr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	s.writeCMCResponse(w, cmcStatusSuccess, -1, cert)
+}
+
+// writeCMCResponse writes a Full PKI Response signed by the CA of the server with the given status and
+// failure reason, if not negative, containing the given certificates.
+func (s *fakeESTServer) writeCMCResponse(w http.ResponseWriter, status, failInfo int, certs ...*x509.Certificate) {
+	info := cmcStatusInfo{Status: status, BodyList: []asn1.RawValue{{FullBytes: []byte{asn1.TagInteger, 1, cmcRequestBodyPartID}}}}
+	if failInfo >= 0 {
+		info.OtherStatusInfo.FullBytes, _ = asn1.Marshal(failInfo)
+	}
+	infoDER, err := asn1.Marshal(info)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	content, err := asn1.Marshal(cmcPKIResponse{
+		ControlSequence: []cmcTaggedAttribute{{
+			BodyPartID: 1,
+			AttrType:   oidCMCStatusInfoV2,
+			AttrValues: []asn1.RawValue{{FullBytes: infoDER}},
+		}},
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	signedData, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	signedData.GetSignedData().ContentInfo.ContentType = oidCMCPKIResponse
+	signedData.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := signedData.AddSigner(s.caCert, s.caKey, pkcs7.SignerInfoConfig{}); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, cert := range certs {
+		signedData.AddCertificate(cert)
+	}
+	der, err := signedData.Finish()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=CMC-response")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(der)))
+}
+
+// sign issues a certificate for the request from the CA of the server.
+func (s *fakeESTServer) sign(csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// issueClientCertificate issues a bootstrap certificate from the CA of the server, valid until the given time,
+// and returns it together with its key in the format of a kubernetes.io/tls Secret.
+func (s *fakeESTServer) issueClientCertificate(commonName string, notAfter time.Time) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-48 * time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, s.caCert, key.Public(), s.caKey)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (s *fakeESTServer) writeCerts(w http.ResponseWriter, certs ...[]byte) {
+	p7, err := pkcs7.DegenerateCertificate(bytes.Join(certs, nil))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(p7)))
+}
+
+// newKeyEncryptionCertificate creates a self-signed RSA certificate generated private keys can be encrypted for,
+// and returns it together with its PEM encoded certificate and key.
+func newKeyEncryptionCertificate() (*x509.Certificate, []byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Key Encryption"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// newTestCertificateRequest creates a PEM encoded PKCS#10 request for the given common name.
+func newTestCertificateRequest(commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: commonName},
+		DNSNames: []string{commonName},
+	}, key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
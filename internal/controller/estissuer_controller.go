@@ -17,19 +17,37 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/utils/pointer"
+	"math/big"
+	"net/http"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"strconv"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"time"
 
 	estClient "github.com/globalsign/est"
 	"github.com/go-logr/logr"
@@ -39,20 +57,36 @@ import (
 // EstIssuerReconciler reconciles a EstIssuer object
 type EstIssuerReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
+const (
+	// defaultHealthCheckInterval is the interval at which issuers are revalidated.
+	defaultHealthCheckInterval = time.Hour
+	// initialHealthCheckBackoff is the delay before a failed validation is retried for the first time.
+	initialHealthCheckBackoff = 10 * time.Second
+
+	// issuerSecretNamesField indexes issuers by the names of the Secrets they reference.
+	issuerSecretNamesField = ".spec.secretNames"
+	// issuerConfigMapNamesField indexes issuers by the names of the ConfigMaps they reference.
+	issuerConfigMapNamesField = ".spec.configMapNames"
+)
+
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estissuers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estissuers/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estissuers/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the EstIssuer object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// The EstIssuer's trust anchors and credentials are loaded from the Secrets in its
+// namespace, and the /cacerts endpoint of the EST portal is requested to check that
+// it is reachable. The CA certificates and the /csrattrs of the portal are cached in
+// the EstIssuer status, which is marked ready once all checks succeed.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.2/pkg/reconcile
@@ -66,16 +100,31 @@ func (r *EstIssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	result, err := reconcileIssuer(ctx, r.Client, r.Recorder, &issuer, issuer.GetSecretNamespace(""), "estissuer-controller")
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Reconciled ESTIssuer", "name", req.NamespacedName, "ready", issuer.Status.Ready)
+	return result, nil
+}
+
+// reconcileIssuer validates an EstIssuer or ClusterEstIssuer, whose Secrets are read from the
+// given namespace, and applies the outcome to its status. The CA certificates of the EST portal
+// are recorded in the status and published, if configured. The issuer is revalidated at its
+// health check interval, and failed validations are retried with an exponential backoff.
+func reconcileIssuer(ctx context.Context, c client.Client, recorder record.EventRecorder, issuer certmanagerv1.GenericIssuer, secretNamespace, fieldManager string) (ctrl.Result, error) {
+	gvk, err := apiutil.GVKForObject(issuer, c.Scheme())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
 	patch := &unstructured.Unstructured{}
-	patch.SetGroupVersionKind(schema.GroupVersionKind{
-		Group:   certmanagerv1.GroupVersion.Group,
-		Version: certmanagerv1.GroupVersion.Version,
-		Kind:    "EstIssuer",
-	})
+	patch.SetGroupVersionKind(gvk)
 	patch.SetNamespace(issuer.GetNamespace())
 	patch.SetName(issuer.GetName())
 	patchOptions := &client.PatchOptions{
-		FieldManager: "estissuer-controller",
+		FieldManager: fieldManager,
 		Force:        pointer.Bool(true),
 	}
 
@@ -83,65 +132,797 @@ func (r *EstIssuerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		PatchOptions: *patchOptions,
 	}
 
-	// Fetch the referenced secret
-	var secret corev1.Secret
-	if err := r.Get(ctx, client.ObjectKey{Namespace: issuer.Namespace, Name: issuer.Spec.AuthSecretName}, &secret); err != nil {
-		log.Error(err, "Referenced secret not found")
+	// Validate the trust anchors, the credentials and the reachability of the portal separately
+	status := issuer.GetStatus()
+	generation := issuer.GetGeneration()
+	var caCerts []*x509.Certificate
+	var reachableErr, publishErr error
+	trust, trustErr := loadIssuerTrust(ctx, c, *issuer.GetSpec(), secretNamespace)
+	clientCert, credentialsErr := validateIssuer(ctx, c, *issuer.GetSpec(), secretNamespace)
+	reachableErr = validateIssuerSpec(*issuer.GetSpec())
+	if trustErr == nil && reachableErr == nil {
+		caCerts, reachableErr = fetchCACerts(ctx, *issuer.GetSpec(), trust)
+		if reachableErr == nil {
+			trustErr = verifyCACerts(caCerts, trust)
+		}
+	}
+
+	if credentialsErr == nil && caCerts != nil && trustErr == nil && issuer.GetSpec().ProbeCredentials {
+		credentialsErr = probeCredentials(ctx, c, *issuer.GetSpec(), secretNamespace, trust)
+	}
+
+	meta.SetStatusCondition(&status.Conditions, issuerCondition(certmanagerv1.IssuerConditionTrustAnchorValid, generation, trustErr,
+		"Valid", "The trust anchors are valid", "InvalidTrustAnchor"))
+	meta.SetStatusCondition(&status.Conditions, issuerCondition(certmanagerv1.IssuerConditionCredentialsValid, generation, credentialsErr,
+		"Valid", "The credentials are valid", "InvalidCredentials"))
+	if caCerts == nil && reachableErr == nil {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               certmanagerv1.IssuerConditionCACertsReachable,
+			Status:             metav1.ConditionUnknown,
+			ObservedGeneration: generation,
+			Reason:             "NotChecked",
+			Message:            "The EST portal cannot be reached without valid trust anchors",
+		})
+	} else {
+		meta.SetStatusCondition(&status.Conditions, issuerCondition(certmanagerv1.IssuerConditionCACertsReachable, generation, reachableErr,
+			"Reachable", "The EST portal serves its CA certificates", "Unreachable"))
+	}
+
+	validationErr := errors.Join(trustErr, credentialsErr, reachableErr)
+	if validationErr == nil {
+		previous := status.CACerts
+		status.CACerts = convertCACerts(caCerts, previous, time.Now())
+		if newCA := detectCARollover(previous, status.CACerts); newCA != nil {
+			recorder.Eventf(issuer, corev1.EventTypeWarning, "CARollover",
+				"The EST portal announced a CA rollover to %s (fingerprint %s), trust bundles must be updated before the current CA expires", newCA.Subject, newCA.Fingerprint)
+		}
+		// the issuer stays ready if the CA certificates cannot be published, which is retried
+		if target := issuer.GetSpec().CACertsTarget; target != nil {
+			if publishErr = publishCACerts(ctx, c, issuer, *target, secretNamespace, caCerts, fieldManager); publishErr != nil {
+				log.FromContext(ctx).Error(publishErr, "Failed to publish CA certificates")
+			}
+		}
+
+		// the CSR attributes are optional, the cached ones are kept if the portal fails to serve them
+		if attrs, err := fetchCSRAttributes(ctx, *issuer.GetSpec(), trust, status.CSRAttributes); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to fetch CSR attributes")
+		} else {
+			status.CSRAttributes = attrs
+		}
+	}
+	setIssuerReady(status, generation)
+	recordHealthCheck(status, generation, validationErr, time.Now())
+	patch.UnstructuredContent()["status"] = *status
+	if err := errors.Join(publishErr, c.Status().Patch(ctx, patch, client.Apply, subPatchOptions)); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// Decode CA certificate
-	explicitAnchor, err := base64.StdEncoding.DecodeString(issuer.Spec.Cacert)
+	if validationErr != nil {
+		backoff := healthCheckBackoff(*issuer.GetSpec(), status.ConsecutiveFailures)
+		log.FromContext(ctx).Error(validationErr, "Issuer validation failed", "retryAfter", backoff)
+		return ctrl.Result{RequeueAfter: backoff}, nil
+	}
+
+	// the issuer is no longer ready once its bootstrap certificate expires
+	requeueAfter := healthCheckInterval(*issuer.GetSpec())
+	if clientCert != nil && time.Until(clientCert.NotAfter)+time.Second < requeueAfter {
+		requeueAfter = time.Until(clientCert.NotAfter) + time.Second
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// validateIssuerSpec checks the location of the portal and the combination of the settings of the issuer,
+// which the schema of the CRD does not cover.
+func validateIssuerSpec(spec certmanagerv1.EstIssuerSpec) error {
+	if errs := spec.Validate(field.NewPath("spec")); len(errs) > 0 {
+		return &issuerConfigError{reason: "InvalidConfiguration", err: errs.ToAggregate()}
+	}
+	return nil
+}
+
+// validateIssuer verifies that the Secrets of the issuer exist in the given namespace and hold the expected keys,
+// and that its bootstrap certificate, if any, is currently valid. The bootstrap certificate is returned for the caller to revalidate
+// the issuer once it expires.
+func validateIssuer(ctx context.Context, c client.Client, spec certmanagerv1.EstIssuerSpec, secretNamespace string) (*x509.Certificate, error) {
+	// Fetch the referenced secret
+	if spec.AuthSecretName != "" {
+		var secret corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Namespace: secretNamespace, Name: spec.AuthSecretName}, &secret); err != nil {
+			return nil, fmt.Errorf("Referenced secret not found: %w", err)
+		}
+		if _, _, err := loadBasicAuthCredentials(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	// Load and check the bootstrap certificate
+	var clientCert *x509.Certificate
+	if spec.ClientCertSecretName != "" {
+		var secret corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Namespace: secretNamespace, Name: spec.ClientCertSecretName}, &secret); err != nil {
+			return nil, fmt.Errorf("Referenced client certificate secret not found: %w", err)
+		}
+		cert, err := loadClientCertificate(secret)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCertificateValidity(cert.Leaf, time.Now()); err != nil {
+			return nil, err
+		}
+		clientCert = cert.Leaf
+	}
+
+	// Load and check the RA certificate Full CMC requests are signed with
+	if spec.FullCMC != nil {
+		var secret corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Namespace: secretNamespace, Name: spec.FullCMC.RASecretName}, &secret); err != nil {
+			return nil, fmt.Errorf("Referenced RA secret not found: %w", err)
+		}
+		cert, err := loadClientCertificate(secret)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCertificateValidity(cert.Leaf, time.Now()); err != nil {
+			return nil, fmt.Errorf("RA certificate: %w", err)
+		}
+	}
+
+	return clientCert, nil
+}
+
+// probeCredentials verifies the credentials of the issuer with an authenticated request to the /csrattrs endpoint,
+// which does not issue a certificate. Only a rejection of the credentials fails the probe, as the portal may not
+// serve CSR attributes at all.
+func probeCredentials(ctx context.Context, c client.Client, spec certmanagerv1.EstIssuerSpec, secretNamespace string, trust issuerTrust) error {
+	httpClient, err := createIssuerClient(ctx, c, spec, secretNamespace, trust.tlsAnchor)
 	if err != nil {
-		return ctrl.Result{}, fmt.Errorf("Failed to decode 'cacert': %v", err)
+		return err
 	}
-	explicitAnchorCertPool, _ := ConvertToCertPool(explicitAnchor)
-	fmt.Println(explicitAnchorCertPool)
+	resp, err := getESTOperation(ctx, httpClient, spec, csrAttrsPath, mimeTypeCSRAttrs)
+	if err != nil {
+		return fmt.Errorf("Failed to verify the credentials: %v", err)
+	}
+	defer resp.Body.Close()
 
-	// Fetch /cacerts endpoint
-	myEstClient := estClient.Client{
-		Host:                  issuer.Spec.Hostname + ":" + strconv.Itoa(issuer.Spec.Port),
-		AdditionalPathSegment: issuer.Spec.Label,
-		ExplicitAnchor:        explicitAnchorCertPool,
-		HostHeader:            "",
-		Username:              "",
-		Password:              "",
-		DisableKeepAlives:     false,
-		InsecureSkipVerify:    false,
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return &issuerConfigError{reason: "CredentialsRejected", err: fmt.Errorf("The EST portal rejected the credentials: %s", readErrorResponse(resp))}
 	}
+	return nil
+}
 
-	// get and verify ca bundle
-	_, err = myEstClient.CACerts(ctx)
+// fetchCACerts fetches the CA certificates from the /cacerts endpoint of the issuer's portal over a connection
+// trusted by the issuer's TLS trust anchor.
+func fetchCACerts(ctx context.Context, spec certmanagerv1.EstIssuerSpec, trust issuerTrust) ([]*x509.Certificate, error) {
+	resp, err := getESTOperation(ctx, createTLSClient(trust.tlsAnchor, nil), spec, caCertsPath, mimeTypePKCS7)
 	if err != nil {
-		issuer.Status.Ready = false
-		patch.UnstructuredContent()["status"] = issuer.Status
-		r.Status().Patch(ctx, patch, client.Apply, subPatchOptions)
-		return ctrl.Result{}, fmt.Errorf("Failed to get or verify 'cacert': %v", err)
+		return nil, fmt.Errorf("Failed to get 'cacerts' from the EST portal: %v", err)
 	}
+	defer resp.Body.Close()
 
-	// Update status
-	issuer.Status.Ready = true
-	patch.UnstructuredContent()["status"] = issuer.Status
-	if err := r.Status().Patch(ctx, patch, client.Apply, subPatchOptions); err != nil {
-		return ctrl.Result{}, err
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to get 'cacerts' from the EST portal: %s", readErrorResponse(resp))
+	}
+	caCerts, err := readCertsResponse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read 'cacerts' from the EST portal: %v", err)
+	}
+	return caCerts, nil
+}
+
+// getESTOperation sends a GET request for an EST operation to the issuer's portal.
+func getESTOperation(ctx context.Context, httpClient *http.Client, spec certmanagerv1.EstIssuerSpec, operation, accept string) (*http.Response, error) {
+	reqURL, err := estEndpointURL(spec, operation)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	return httpClient.Do(req)
+}
+
+// verifyCACerts verifies that the CA certificates served by the EST portal chain to the expected CA bundle, if any.
+func verifyCACerts(caCerts []*x509.Certificate, trust issuerTrust) error {
+	if trust.caBundle != nil && !chainsToCABundle(caCerts, trust.caBundle) {
+		return &issuerConfigError{reason: "CABundleMismatch", err: errors.New("The CA certificates served by the EST portal do not chain to 'caBundle'")}
+	}
+	return nil
+}
+
+// convertCACerts describes the CA certificates served by the EST portal, and the roles of the
+// certificates in a root CA key update, if the portal announces one. The fetch time of the previous
+// CA certificates is kept while the portal serves the same certificates, so that the status only
+// changes along with them.
+func convertCACerts(certs []*x509.Certificate, previous *certmanagerv1.CACerts, now time.Time) *certmanagerv1.CACerts {
+	roles := classifyCARollover(certs)
+	result := &certmanagerv1.CACerts{
+		LastFetchedTime: &metav1.Time{Time: now},
+	}
+	for i, cert := range certs {
+		fingerprint := sha256.Sum256(cert.Raw)
+		result.Certificates = append(result.Certificates, certmanagerv1.CACertificate{
+			Subject:      cert.Subject.String(),
+			Issuer:       cert.Issuer.String(),
+			SerialNumber: cert.SerialNumber.Text(16),
+			Fingerprint:  hex.EncodeToString(fingerprint[:]),
+			NotBefore:    metav1.Time{Time: cert.NotBefore},
+			NotAfter:     metav1.Time{Time: cert.NotAfter},
+			Rollover:     roles[i],
+		})
+	}
+	if previous != nil && previous.LastFetchedTime != nil && sameCACertificates(previous.Certificates, result.Certificates) {
+		result.LastFetchedTime = previous.LastFetchedTime
+	}
+	return result
+}
+
+// sameCACertificates reports whether two lists of CA certificates hold the same certificates in the same order.
+func sameCACertificates(a, b []certmanagerv1.CACertificate) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Fingerprint != b[i].Fingerprint {
+			return false
+		}
+	}
+	return true
+}
+
+// classifyCARollover identifies the certificates of a root CA key update (RFC 7030 Sec. 4.1.3) by their
+// position. The newest self-signed CA certificate holds the new key, and the link certificates between
+// the old and the new key are self-issued by the same CA, signed by one key for the other. Certificates
+// of other CAs, such as intermediates issued by the root, have no role.
+func classifyCARollover(certs []*x509.Certificate) []certmanagerv1.CARolloverRole {
+	roles := make([]certmanagerv1.CARolloverRole, len(certs))
+
+	var newest *x509.Certificate
+	newestIndex := -1
+	for i, cert := range certs {
+		if cert.IsCA && cert.CheckSignatureFrom(cert) == nil && (newest == nil || cert.NotBefore.After(newest.NotBefore)) {
+			newest, newestIndex = cert, i
+		}
+	}
+	if newest == nil {
+		return roles
 	}
 
-	log.Info("Successfully reconciled ESTIssuer", "name", req.NamespacedName)
-	return ctrl.Result{}, nil
+	for i, cert := range certs {
+		if !cert.IsCA || cert.CheckSignatureFrom(cert) == nil ||
+			!bytes.Equal(cert.RawIssuer, cert.RawSubject) || !bytes.Equal(cert.RawSubject, newest.RawSubject) {
+			continue
+		}
+		for _, signer := range certs {
+			if signer == cert || cert.CheckSignatureFrom(signer) != nil || samePublicKey(cert, signer) {
+				continue
+			}
+			switch {
+			case samePublicKey(cert, newest):
+				roles[i] = certmanagerv1.CARolloverNewWithOld
+			case samePublicKey(signer, newest):
+				roles[i] = certmanagerv1.CARolloverOldWithNew
+			}
+		}
+		if roles[i] != "" {
+			roles[newestIndex] = certmanagerv1.CARolloverNewWithNew
+		}
+	}
+	return roles
+}
+
+// samePublicKey reports whether both certificates hold the same public key.
+func samePublicKey(a, b *x509.Certificate) bool {
+	return bytes.Equal(a.RawSubjectPublicKeyInfo, b.RawSubjectPublicKeyInfo)
+}
+
+// detectCARollover returns the new CA certificate if the current CA certificates announce a root CA key
+// update, which the previous ones did not.
+func detectCARollover(previous, current *certmanagerv1.CACerts) *certmanagerv1.CACertificate {
+	announced := map[string]bool{}
+	if previous != nil {
+		for _, cert := range previous.Certificates {
+			if cert.Rollover == certmanagerv1.CARolloverNewWithNew {
+				announced[cert.Fingerprint] = true
+			}
+		}
+	}
+	for i, cert := range current.Certificates {
+		if cert.Rollover == certmanagerv1.CARolloverNewWithNew && !announced[cert.Fingerprint] {
+			return &current.Certificates[i]
+		}
+	}
+	return nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// publishCACerts applies the PEM encoded CA certificates to the ConfigMap or Secret of the target, which
+// is created in the given namespace and owned by the issuer.
+func publishCACerts(ctx context.Context, c client.Client, issuer certmanagerv1.GenericIssuer, target certmanagerv1.CACertsTarget,
+	namespace string, caCerts []*x509.Certificate, fieldManager string) error {
+	key := target.Key
+	if key == "" {
+		key = "ca.crt"
+	}
+	data := encodeCertificates(caCerts)
+
+	var obj client.Object
+	if target.Kind == "Secret" {
+		obj = &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			Data:     map[string][]byte{key: data},
+		}
+	} else {
+		obj = &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			Data:     map[string]string{key: string(data)},
+		}
+	}
+	obj.SetName(target.Name)
+	obj.SetNamespace(namespace)
+	if err := ctrl.SetControllerReference(issuer, obj, c.Scheme()); err != nil {
+		return err
+	}
+	if err := c.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership); err != nil {
+		return fmt.Errorf("Failed to publish CA certificates to %s %s: %w", obj.GetObjectKind().GroupVersionKind().Kind, target.Name, err)
+	}
+	return nil
+}
+
+// issuerTrust holds the trust anchors of an issuer.
+type issuerTrust struct {
+	// tlsAnchor authenticates the TLS server of the EST portal, the system trust store is used if nil.
+	tlsAnchor *x509.CertPool
+	// caBundle holds the CA certificates the EST portal is expected to issue under, if configured.
+	caBundle *x509.CertPool
+}
+
+// issuerConfigError is a validation error caused by a malformed configuration of the issuer,
+// which is reported with its own reason in the Ready condition.
+type issuerConfigError struct {
+	reason string
+	err    error
+}
+
+func (e *issuerConfigError) Error() string {
+	return e.err.Error()
+}
+
+func (e *issuerConfigError) Unwrap() error {
+	return e.err
+}
+
+// loadIssuerTrust loads the TLS trust anchor and the CA bundle of the issuer. ConfigMaps and Secrets
+// holding the certificates are read from the given namespace.
+func loadIssuerTrust(ctx context.Context, c client.Client, spec certmanagerv1.EstIssuerSpec, secretNamespace string) (issuerTrust, error) {
+	var trust issuerTrust
+
+	var tlsAnchor []*x509.Certificate
+	switch {
+	case spec.TLSTrustAnchor != nil:
+		certs, err := loadCertificateSource(ctx, c, *spec.TLSTrustAnchor, secretNamespace)
+		if err != nil {
+			return trust, &issuerConfigError{reason: "InvalidTrustAnchor", err: fmt.Errorf("Invalid 'tlsTrustAnchor': %w", err)}
+		}
+		tlsAnchor = certs
+	case spec.Cacert != "":
+		data, err := base64.StdEncoding.DecodeString(spec.Cacert)
+		if err != nil {
+			return trust, &issuerConfigError{reason: "InvalidTrustAnchor", err: fmt.Errorf("Failed to decode 'cacert': %w", err)}
+		}
+		if tlsAnchor, err = parsePEMCertificates(data); err != nil {
+			return trust, &issuerConfigError{reason: "InvalidTrustAnchor", err: fmt.Errorf("Failed to parse 'cacert': %w", err)}
+		}
+	}
+	if tlsAnchor != nil {
+		trust.tlsAnchor = newCertPool(tlsAnchor)
+	}
+
+	if spec.CABundle != nil {
+		certs, err := loadCertificateSource(ctx, c, *spec.CABundle, secretNamespace)
+		if err != nil {
+			return trust, &issuerConfigError{reason: "InvalidCABundle", err: fmt.Errorf("Invalid 'caBundle': %w", err)}
+		}
+		trust.caBundle = newCertPool(certs)
+	}
+	return trust, nil
+}
+
+// loadCertificateSource reads and parses the certificates the source refers to.
+func loadCertificateSource(ctx context.Context, c client.Client, source certmanagerv1.CertificateSource, namespace string) ([]*x509.Certificate, error) {
+	var data []byte
+	switch {
+	case source.ConfigMapRef != nil:
+		var configMap corev1.ConfigMap
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: source.ConfigMapRef.Name}, &configMap); err != nil {
+			return nil, fmt.Errorf("Referenced configmap not found: %w", err)
+		}
+		key := sourceKey(*source.ConfigMapRef)
+		value, ok := configMap.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("Key %q not found in configmap %s", key, configMap.Name)
+		}
+		data = []byte(value)
+	case source.SecretRef != nil:
+		var secret corev1.Secret
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: source.SecretRef.Name}, &secret); err != nil {
+			return nil, fmt.Errorf("Referenced secret not found: %w", err)
+		}
+		key := sourceKey(*source.SecretRef)
+		value, ok := secret.Data[key]
+		if !ok {
+			return nil, fmt.Errorf("Key %q not found in secret %s", key, secret.Name)
+		}
+		data = value
+	default:
+		data = []byte(source.Inline)
+	}
+	return parsePEMCertificates(data)
+}
+
+// sourceKey returns the key of a ConfigMap or Secret the certificates are read from.
+func sourceKey(selector certmanagerv1.KeySelector) string {
+	if selector.Key == "" {
+		return "ca.crt"
+	}
+	return selector.Key
+}
+
+// parsePEMCertificates strictly parses PEM encoded certificates. Unlike x509.CertPool.AppendCertsFromPEM,
+// it rejects blocks of other types, malformed certificates, and data outside of PEM blocks.
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := bytes.TrimSpace(data)
+	for len(rest) > 0 {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("malformed PEM data")
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block of type %q", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("malformed certificate %d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+		rest = bytes.TrimSpace(rest)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	return certs, nil
+}
+
+// newCertPool creates a pool of the certificates.
+func newCertPool(certs []*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+// verifyCertificateChain verifies that the certificate chains to one of the certificates of the CA bundle,
+// using the intermediate certificates to build the chain.
+func verifyCertificateChain(cert *x509.Certificate, intermediates []*x509.Certificate, caBundle *x509.CertPool) error {
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         caBundle,
+		Intermediates: newCertPool(intermediates),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// chainsToCABundle reports whether one of the CA certificates chains to the CA bundle. Certificates
+// served by /cacerts besides the current CA, e.g. for a CA rollover, are not required to.
+func chainsToCABundle(caCerts []*x509.Certificate, caBundle *x509.CertPool) bool {
+	for i, cert := range caCerts {
+		others := append(append([]*x509.Certificate{}, caCerts[:i]...), caCerts[i+1:]...)
+		if verifyCertificateChain(cert, others, caBundle) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	oidExtensionRequest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+	oidPublicKeyECDSA   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidPublicKeyRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// fetchCSRAttributes fetches the CSR attributes from the /csrattrs endpoint of the issuer's portal. A portal
+// without CSR attributes answers with 204 or 404 (RFC 7030 Sec. 4.5.2), which is an empty set of attributes.
+// The previous attributes are returned if they are unchanged.
+func fetchCSRAttributes(ctx context.Context, spec certmanagerv1.EstIssuerSpec, trust issuerTrust, previous *certmanagerv1.CSRAttributes) (*certmanagerv1.CSRAttributes, error) {
+	resp, err := getESTOperation(ctx, createTLSClient(trust.tlsAnchor, nil), spec, csrAttrsPath, mimeTypeCSRAttrs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get 'csrattrs': %v", err)
+	}
+	defer resp.Body.Close()
+
+	var attrs estClient.CSRAttrs
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusNotFound:
+	case http.StatusOK:
+		der, err := readBase64Body(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read 'csrattrs': %v", err)
+		}
+		if err := attrs.Unmarshal(der); err != nil {
+			return nil, fmt.Errorf("Failed to read 'csrattrs': %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("Failed to get 'csrattrs': %s", readErrorResponse(resp))
+	}
+	return convertCSRAttributes(attrs, previous, time.Now()), nil
+}
+
+// convertCSRAttributes interprets the CSR attributes as described in RFC 7030 Sec. 4.5.2. Extension requests
+// list the required extensions, and public key attributes the required curve or key size. Bare OIDs and
+// unknown attributes are recorded as OIDs. The previous attributes, and thus their fetch time, are kept if
+// the portal requires the same attributes, so that the status only changes along with them.
+func convertCSRAttributes(attrs estClient.CSRAttrs, previous *certmanagerv1.CSRAttributes, now time.Time) *certmanagerv1.CSRAttributes {
+	result := &certmanagerv1.CSRAttributes{
+		LastFetchedTime: &metav1.Time{Time: now},
+	}
+	for _, oid := range attrs.OIDs {
+		result.OIDs = append(result.OIDs, oid.String())
+	}
+
+	for _, attr := range attrs.Attributes {
+		switch {
+		case attr.Type.Equal(oidExtensionRequest):
+			for _, value := range attr.Values {
+				if oid, ok := value.(asn1.ObjectIdentifier); ok {
+					result.RequiredExtensions = append(result.RequiredExtensions, oid.String())
+				}
+			}
+		case attr.Type.Equal(oidPublicKeyECDSA):
+			result.KeyType = "ECDSA"
+			for _, value := range attr.Values {
+				if oid, ok := value.(asn1.ObjectIdentifier); ok {
+					result.KeyCurve = oid.String()
+				}
+			}
+		case attr.Type.Equal(oidPublicKeyRSA):
+			result.KeyType = "RSA"
+			for _, value := range attr.Values {
+				if size, ok := value.(*big.Int); ok && size.IsInt64() {
+					result.KeySize = int(size.Int64())
+				}
+			}
+		default:
+			result.OIDs = append(result.OIDs, attr.Type.String())
+		}
+	}
+
+	if previous != nil {
+		unchanged := result.DeepCopy()
+		unchanged.LastFetchedTime = previous.LastFetchedTime
+		if equality.Semantic.DeepEqual(unchanged, previous) {
+			return previous
+		}
+	}
+	return result
+}
+
+// recordHealthCheck records the time and the generation of a validation of the issuer, and counts
+// the failed validations in a row. The count restarts when the issuer has been changed.
+func recordHealthCheck(status *certmanagerv1.EstIssuerStatus, generation int64, err error, now time.Time) {
+	if status.ObservedGeneration != generation || err == nil {
+		status.ConsecutiveFailures = 0
+	}
+	if err != nil {
+		status.ConsecutiveFailures++
+	}
+	status.LastCheckedTime = &metav1.Time{Time: now}
+	status.ObservedGeneration = generation
+}
+
+// healthCheckInterval returns the interval at which the issuer is revalidated.
+func healthCheckInterval(spec certmanagerv1.EstIssuerSpec) time.Duration {
+	if spec.HealthCheckInterval != nil && spec.HealthCheckInterval.Duration > 0 {
+		return spec.HealthCheckInterval.Duration
+	}
+	return defaultHealthCheckInterval
+}
+
+// healthCheckBackoff returns the delay before a failed validation is retried, which doubles with
+// every failure in a row up to the health check interval of the issuer.
+func healthCheckBackoff(spec certmanagerv1.EstIssuerSpec, failures int32) time.Duration {
+	interval := healthCheckInterval(spec)
+	backoff := initialHealthCheckBackoff
+	for i := int32(1); i < failures && backoff < interval; i++ {
+		backoff *= 2
+	}
+	if backoff > interval {
+		return interval
+	}
+	return backoff
+}
+
+// issuerCondition returns a condition of the issuer reflecting the result of a validation step. Errors
+// carrying their own reason are reported with it, other errors with the given failure reason.
+func issuerCondition(conditionType string, generation int64, err error, reason, message, failureReason string) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               conditionType,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            message,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = failureReason
+		condition.Message = err.Error()
+		var configErr *issuerConfigError
+		if errors.As(err, &configErr) {
+			condition.Reason = configErr.reason
+		}
+	}
+	return condition
+}
+
+// setIssuerReady sets the Ready field and condition of an issuer from its other conditions. An issuer
+// is ready if all of them are true, otherwise the first one which is not explains why.
+func setIssuerReady(status *certmanagerv1.EstIssuerStatus, generation int64) {
+	condition := metav1.Condition{
+		Type:               certmanagerv1.IssuerConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             "Validated",
+		Message:            "EST portal is reachable and trusted",
+	}
+	for _, conditionType := range []string{
+		certmanagerv1.IssuerConditionTrustAnchorValid,
+		certmanagerv1.IssuerConditionCredentialsValid,
+		certmanagerv1.IssuerConditionCACertsReachable,
+	} {
+		if c := meta.FindStatusCondition(status.Conditions, conditionType); c == nil || c.Status != metav1.ConditionTrue {
+			condition.Status = metav1.ConditionFalse
+			if c != nil {
+				condition.Reason = c.Reason
+				condition.Message = c.Message
+			}
+			break
+		}
+	}
+
+	status.Ready = condition.Status == metav1.ConditionTrue
+	meta.SetStatusCondition(&status.Conditions, condition)
+}
+
+// issuerSecretNames returns the names of all Secrets referenced by the issuer.
+func issuerSecretNames(spec certmanagerv1.EstIssuerSpec) []string {
+	var names []string
+	for _, name := range []string{spec.AuthSecretName, spec.ClientCertSecretName} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	if spec.FullCMC != nil {
+		names = append(names, spec.FullCMC.RASecretName)
+	}
+	for _, source := range []*certmanagerv1.CertificateSource{spec.TLSTrustAnchor, spec.CABundle} {
+		if source != nil && source.SecretRef != nil {
+			names = append(names, source.SecretRef.Name)
+		}
+	}
+	return names
+}
+
+// issuerConfigMapNames returns the names of all ConfigMaps referenced by the issuer.
+func issuerConfigMapNames(spec certmanagerv1.EstIssuerSpec) []string {
+	var names []string
+	for _, source := range []*certmanagerv1.CertificateSource{spec.TLSTrustAnchor, spec.CABundle} {
+		if source != nil && source.ConfigMapRef != nil {
+			names = append(names, source.ConfigMapRef.Name)
+		}
+	}
+	return names
+}
+
+// indexIssuerReferences indexes issuers of the given kind by the names of the Secrets and ConfigMaps they reference.
+func indexIssuerReferences(indexer client.FieldIndexer, issuer certmanagerv1.GenericIssuer) error {
+	if err := indexer.IndexField(context.Background(), issuer, issuerSecretNamesField, func(obj client.Object) []string {
+		return issuerSecretNames(*obj.(certmanagerv1.GenericIssuer).GetSpec())
+	}); err != nil {
+		return err
+	}
+	return indexer.IndexField(context.Background(), issuer, issuerConfigMapNamesField, func(obj client.Object) []string {
+		return issuerConfigMapNames(*obj.(certmanagerv1.GenericIssuer).GetSpec())
+	})
+}
+
+// findEstIssuersReferencing lists the EstIssuers referencing the Secret or ConfigMap in the given field index,
+// which can only be those in its namespace.
+func findEstIssuersReferencing(ctx context.Context, c client.Reader, obj client.Object, field string) ([]certmanagerv1.EstIssuer, error) {
+	var issuers certmanagerv1.EstIssuerList
+	if err := c.List(ctx, &issuers, client.InNamespace(obj.GetNamespace()), client.MatchingFields{field: obj.GetName()}); err != nil {
+		return nil, err
+	}
+	return issuers.Items, nil
+}
+
+// findClusterEstIssuersReferencing lists the ClusterEstIssuers referencing the Secret or ConfigMap in the given
+// field index, which can only be those in the cluster resource namespace.
+func findClusterEstIssuersReferencing(ctx context.Context, c client.Reader, obj client.Object, field, clusterResourceNamespace string) ([]certmanagerv1.ClusterEstIssuer, error) {
+	if obj.GetNamespace() != clusterResourceNamespace {
+		return nil, nil
+	}
+	var issuers certmanagerv1.ClusterEstIssuerList
+	if err := c.List(ctx, &issuers, client.MatchingFields{field: obj.GetName()}); err != nil {
+		return nil, err
+	}
+	return issuers.Items, nil
+}
+
+// issuerBecameReady filters issuer events down to updates making the issuer ready, so that the requests
+// waiting for the issuer are retried right away.
+func issuerBecameReady() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldIssuer, ok := e.ObjectOld.(certmanagerv1.GenericIssuer)
+			if !ok {
+				return false
+			}
+			newIssuer, ok := e.ObjectNew.(certmanagerv1.GenericIssuer)
+			return ok && !oldIssuer.GetStatus().Ready && newIssuer.GetStatus().Ready
+		},
+	}
+}
+
+// issuerListOptions returns the kind of the issuer, and the options listing the objects which may refer to it:
+// those in the namespace of an EstIssuer, and those in all namespaces for a ClusterEstIssuer.
+func issuerListOptions(issuer client.Object) (string, []client.ListOption) {
+	if _, ok := issuer.(*certmanagerv1.ClusterEstIssuer); ok {
+		return certmanagerv1.ClusterEstIssuerKind, nil
+	}
+	return certmanagerv1.EstIssuerKind, []client.ListOption{client.InNamespace(issuer.GetNamespace())}
+}
+
+// SetupWithManager sets up the controller with the Manager. Only changes of the spec trigger a validation, the
+// status updates of a validation do not, so that failed validations are retried with their backoff.
 func (r *EstIssuerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := indexIssuerReferences(mgr.GetFieldIndexer(), &certmanagerv1.EstIssuer{}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&certmanagerv1.EstIssuer{}).
+		For(&certmanagerv1.EstIssuer{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.findIssuersReferencing(issuerSecretNamesField)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+		).
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.findIssuersReferencing(issuerConfigMapNamesField)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+		).
 		Complete(r)
 }
 
-// ConvertToCertPool converts a PEM-encoded byte slice into an x509.CertPool
-func ConvertToCertPool(pemData []byte) (*x509.CertPool, error) {
-	certPool := x509.NewCertPool()
-	if ok := certPool.AppendCertsFromPEM(pemData); !ok {
-		return nil, errors.New("failed to append certificates to cert pool")
+// findIssuersReferencing maps a Secret or ConfigMap to the EstIssuers referencing it in the given field index,
+// so that rotated credentials and trust anchors are validated again.
+func (r *EstIssuerReconciler) findIssuersReferencing(field string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		issuers, err := findEstIssuersReferencing(ctx, r.Client, obj, field)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed to list EstIssuers", "field", field, "name", obj.GetName())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, len(issuers))
+		for i, issuer := range issuers {
+			requests[i] = reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&issuer)}
+		}
+		return requests
 	}
-	return certPool, nil
 }
@@ -18,11 +18,30 @@ package controller
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
 
+	"github.com/globalsign/est"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/config"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,16 +52,35 @@ import (
 var _ = Describe("EstIssuer Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
+		const secretName = "test-estissuer-credentials"
 
 		ctx := context.Background()
 
 		typeNamespacedName := types.NamespacedName{
 			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
+			Namespace: "default",
 		}
 		estissuer := &certmanagerv1.EstIssuer{}
 
+		var estServer *fakeESTServer
+
 		BeforeEach(func() {
+			estServer = newFakeESTServer("estuser", "estpwd")
+
+			By("creating the credentials the EstIssuer refers to")
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      secretName,
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeBasicAuth,
+				Data: map[string][]byte{
+					corev1.BasicAuthUsernameKey: []byte("estuser"),
+					corev1.BasicAuthPasswordKey: []byte("estpwd"),
+				},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
 			By("creating the custom resource for the Kind EstIssuer")
 			err := k8sClient.Get(ctx, typeNamespacedName, estissuer)
 			if err != nil && errors.IsNotFound(err) {
@@ -51,34 +89,560 @@ var _ = Describe("EstIssuer Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: certmanagerv1.EstIssuerSpec{
+						Hostname:       estServer.hostname(),
+						Port:           estServer.port(),
+						Cacert:         estServer.trustAnchor(),
+						AuthSecretName: secretName,
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
 		})
 
 		AfterEach(func() {
-			// TODO(user): Cleanup logic after each test, like removing the resource instance.
 			resource := &certmanagerv1.EstIssuer{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
 			By("Cleanup the specific resource instance EstIssuer")
 			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "default"}})).To(Succeed())
+			estServer.Close()
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &EstIssuerReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, certmanagerv1.IssuerConditionReady)).To(BeTrue())
+			Expect(resource.Status.LastCheckedTime).NotTo(BeNil())
+			Expect(resource.Status.ObservedGeneration).To(Equal(resource.Generation))
+			for _, conditionType := range []string{
+				certmanagerv1.IssuerConditionTrustAnchorValid,
+				certmanagerv1.IssuerConditionCredentialsValid,
+				certmanagerv1.IssuerConditionCACertsReachable,
+			} {
+				Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, conditionType)).To(BeTrue(), conditionType)
+			}
+		})
+		It("should cache the CSR attributes of the portal", func() {
+			estServer.csrAttrs = &est.CSRAttrs{
+				OIDs: []asn1.ObjectIdentifier{{1, 2, 840, 10045, 4, 3, 2}},
+				Attributes: []est.Attribute{
+					{Type: oidExtensionRequest, Values: est.AttributeValueSET{asn1.ObjectIdentifier{2, 5, 29, 17}}},
+					{Type: oidPublicKeyECDSA, Values: est.AttributeValueSET{asn1.ObjectIdentifier{1, 3, 132, 0, 34}}},
+				},
+			}
+
+			controllerReconciler := &EstIssuerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CSRAttributes).NotTo(BeNil())
+			Expect(resource.Status.CSRAttributes.OIDs).To(ConsistOf("1.2.840.10045.4.3.2"))
+			Expect(resource.Status.CSRAttributes.RequiredExtensions).To(ConsistOf("2.5.29.17"))
+			Expect(resource.Status.CSRAttributes.KeyType).To(Equal("ECDSA"))
+			Expect(resource.Status.CSRAttributes.KeyCurve).To(Equal("1.3.132.0.34"))
+
+			By("Fetching the same attributes again")
+			fetched := resource.Status.CSRAttributes.LastFetchedTime
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CSRAttributes.LastFetchedTime).To(Equal(fetched))
+		})
+		It("should record and publish the CA certificates of the portal", func() {
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.CACertsTarget = &certmanagerv1.CACertsTarget{Kind: "ConfigMap", Name: "test-estissuer-cacerts"}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstIssuerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(defaultHealthCheckInterval))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.CACerts).NotTo(BeNil())
+			Expect(resource.Status.CACerts.Certificates).To(HaveLen(1))
+			Expect(resource.Status.CACerts.Certificates[0].Subject).To(Equal("CN=Fake EST Root CA"))
+			Expect(resource.Status.CACerts.Certificates[0].Fingerprint).To(HaveLen(64))
+
+			published := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test-estissuer-cacerts", Namespace: "default"}, published)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, published)).To(Succeed())
+			}()
+			Expect(published.Data).To(HaveKeyWithValue("ca.crt", estServer.caPEM()))
+			Expect(published.OwnerReferences).To(HaveLen(1))
+			Expect(published.OwnerReferences[0].Name).To(Equal(resourceName))
+		})
+		It("should report a malformed trust anchor in the Ready condition", func() {
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Cacert = base64.StdEncoding.EncodeToString([]byte("-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydGlmaWNhdGU=\n-----END CERTIFICATE-----\n"))
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstIssuerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(initialHealthCheckBackoff))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeFalse())
+			condition := meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.IssuerConditionReady)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Reason).To(Equal("InvalidTrustAnchor"))
+			Expect(condition.Message).To(ContainSubstring("Failed to parse 'cacert'"))
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, certmanagerv1.IssuerConditionTrustAnchorValid)).To(BeTrue())
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, certmanagerv1.IssuerConditionCredentialsValid)).To(BeTrue())
+			reachable := meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.IssuerConditionCACertsReachable)
+			Expect(reachable).NotTo(BeNil())
+			Expect(reachable.Status).To(Equal(metav1.ConditionUnknown))
+		})
+		It("should verify the CA certificates of the portal against the CA bundle", func() {
+			By("Referencing the TLS trust anchor inline and the CA bundle from a ConfigMap")
+			caBundle := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estissuer-ca-bundle",
+					Namespace: "default",
+				},
+				Data: map[string]string{
+					"ca.crt": estServer.caPEM(),
+				},
+			}
+			Expect(k8sClient.Create(ctx, caBundle)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, caBundle)).To(Succeed())
+			}()
+
+			tlsAnchor, err := base64.StdEncoding.DecodeString(estServer.trustAnchor())
+			Expect(err).NotTo(HaveOccurred())
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Cacert = ""
+			resource.Spec.TLSTrustAnchor = &certmanagerv1.CertificateSource{Inline: string(tlsAnchor)}
+			resource.Spec.CABundle = &certmanagerv1.CertificateSource{ConfigMapRef: &certmanagerv1.KeySelector{Name: caBundle.Name}}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstIssuerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeTrue())
+
+			By("Expecting a CA the portal does not issue under")
+			otherCA, _, _ := newKeyEncryptionCertificate()
+			caBundle.Data["ca.crt"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCA.Raw}))
+			Expect(k8sClient.Update(ctx, caBundle)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeFalse())
+			Expect(meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.IssuerConditionReady).Message).To(ContainSubstring("do not chain to 'caBundle'"))
+		})
+		It("should not be ready when the bootstrap certificate has expired", func() {
+			By("Configuring an expired bootstrap certificate")
+			certPEM, keyPEM := estServer.issueClientCertificate("bootstrap.jquad.rocks", time.Now().Add(-time.Hour))
+			clientCertSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estissuer-bootstrap",
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM,
+					corev1.TLSPrivateKeyKey: keyPEM,
+				},
+			}
+			Expect(k8sClient.Create(ctx, clientCertSecret)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, clientCertSecret)).To(Succeed())
+			}()
+
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.ClientCertSecretName = clientCertSecret.Name
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstIssuerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeFalse())
+			Expect(meta.IsStatusConditionFalse(resource.Status.Conditions, certmanagerv1.IssuerConditionReady)).To(BeTrue())
+			Expect(meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.IssuerConditionReady).Message).To(ContainSubstring("expired"))
+
+			By("Backing off exponentially while the validation keeps failing")
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(2 * initialHealthCheckBackoff))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.ConsecutiveFailures).To(BeEquivalentTo(2))
+		})
+		It("should report a credential secret without password", func() {
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: secretName, Namespace: "default"}, secret)).To(Succeed())
+			delete(secret.Data, corev1.BasicAuthPasswordKey)
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+
+			controllerReconciler := &EstIssuerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeFalse())
+			condition := meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.IssuerConditionCredentialsValid)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+			Expect(condition.Reason).To(Equal("InvalidCredentials"))
+			Expect(condition.Message).To(ContainSubstring(`missing the key "password"`))
+		})
+		It("should probe the credentials at the CSR attributes endpoint", func() {
+			estServer.authenticateCSRAttrs = true
+			resource := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.ProbeCredentials = true
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstIssuerReconciler{
+				Client:   k8sClient,
+				Scheme:   k8sClient.Scheme(),
+				Recorder: record.NewFakeRecorder(10),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeTrue())
+
+			By("Rotating the credentials to a password the portal rejects")
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: secretName, Namespace: "default"}, secret)).To(Succeed())
+			secret.Data[corev1.BasicAuthPasswordKey] = []byte("wrong")
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Ready).To(BeFalse())
+			condition := meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.IssuerConditionCredentialsValid)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Reason).To(Equal("CredentialsRejected"))
+		})
+		It("should not revalidate a failing issuer before its backoff expires", func() {
+			By("Shutting down the portal")
+			estServer.Close()
+
+			By("Running the controller in a manager")
+			mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+				Scheme:     k8sClient.Scheme(),
+				Metrics:    metricsserver.Options{BindAddress: "0"},
+				Controller: config.Controller{SkipNameValidation: pointer.Bool(true)},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect((&EstIssuerReconciler{
+				Client:   mgr.GetClient(),
+				Scheme:   mgr.GetScheme(),
+				Recorder: mgr.GetEventRecorderFor("estissuer-controller"),
+			}).SetupWithManager(mgr)).To(Succeed())
+			mgrCtx, cancel := context.WithCancel(ctx)
+			DeferCleanup(cancel)
+			go func() {
+				defer GinkgoRecover()
+				Expect(mgr.Start(mgrCtx)).To(Succeed())
+			}()
+
+			resource := &certmanagerv1.EstIssuer{}
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+				g.Expect(resource.Status.ConsecutiveFailures).To(BeEquivalentTo(1))
+			}, 10*time.Second, 100*time.Millisecond).Should(Succeed())
+			Expect(resource.Status.Ready).To(BeFalse())
+
+			By("Checking that the status update of the validation does not trigger another one")
+			Consistently(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+				g.Expect(resource.Status.ConsecutiveFailures).To(BeEquivalentTo(1))
+			}, initialHealthCheckBackoff/2, 250*time.Millisecond).Should(Succeed())
 		})
 	})
 })
+
+var _ = Describe("CA rollover", func() {
+	newRoot := func(name string, notBefore time.Time) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(notBefore.UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             notBefore,
+			NotAfter:              notBefore.Add(365 * 24 * time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		Expect(err).NotTo(HaveOccurred())
+		cert, err := x509.ParseCertificate(der)
+		Expect(err).NotTo(HaveOccurred())
+		return cert, key
+	}
+	link := func(subject *x509.Certificate, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey) *x509.Certificate {
+		template := *subject
+		template.SerialNumber = big.NewInt(time.Now().UnixNano())
+		der, err := x509.CreateCertificate(rand.Reader, &template, issuer, subject.PublicKey, issuerKey)
+		Expect(err).NotTo(HaveOccurred())
+		cert, err := x509.ParseCertificate(der)
+		Expect(err).NotTo(HaveOccurred())
+		return cert
+	}
+
+	It("should classify the certificates of a root CA key update", func() {
+		oldWithOld, oldKey := newRoot("Root CA", time.Now().Add(-24*time.Hour))
+		newWithNew, newKey := newRoot("Root CA", time.Now().Add(-time.Hour))
+		oldWithNew := link(oldWithOld, newWithNew, newKey)
+		newWithOld := link(newWithNew, oldWithOld, oldKey)
+
+		roles := classifyCARollover([]*x509.Certificate{oldWithOld, newWithNew, oldWithNew, newWithOld})
+		Expect(roles).To(Equal([]certmanagerv1.CARolloverRole{
+			"",
+			certmanagerv1.CARolloverNewWithNew,
+			certmanagerv1.CARolloverOldWithNew,
+			certmanagerv1.CARolloverNewWithOld,
+		}))
+	})
+
+	It("should not report a rollover for a single CA", func() {
+		root, _ := newRoot("Root CA", time.Now().Add(-time.Hour))
+		Expect(classifyCARollover([]*x509.Certificate{root})).To(Equal([]certmanagerv1.CARolloverRole{""}))
+	})
+
+	It("should not report a rollover for a root and its intermediate CA", func() {
+		root, rootKey := newRoot("Root CA", time.Now().Add(-time.Hour))
+		intermediate, _ := newRoot("Intermediate CA", time.Now().Add(-time.Hour))
+		intermediate = link(intermediate, root, rootKey)
+
+		roles := classifyCARollover([]*x509.Certificate{root, intermediate})
+		Expect(roles).To(Equal([]certmanagerv1.CARolloverRole{"", ""}))
+		Expect(detectCARollover(nil, convertCACerts([]*x509.Certificate{root, intermediate}, nil, time.Now()))).To(BeNil())
+	})
+
+	It("should only report a rollover once", func() {
+		oldWithOld, oldKey := newRoot("Root CA", time.Now().Add(-24*time.Hour))
+		newWithNew, _ := newRoot("Root CA", time.Now().Add(-time.Hour))
+		newWithOld := link(newWithNew, oldWithOld, oldKey)
+
+		before := convertCACerts([]*x509.Certificate{oldWithOld}, nil, time.Now())
+		during := convertCACerts([]*x509.Certificate{oldWithOld, newWithNew, newWithOld}, nil, time.Now())
+		newCA := detectCARollover(before, during)
+		Expect(newCA).NotTo(BeNil())
+		Expect(newCA.Rollover).To(Equal(certmanagerv1.CARolloverNewWithNew))
+		Expect(detectCARollover(during, during)).To(BeNil())
+	})
+
+	It("should keep the fetch time while the portal serves the same certificates", func() {
+		oldWithOld, oldKey := newRoot("Root CA", time.Now().Add(-24*time.Hour))
+		newWithNew, _ := newRoot("Root CA", time.Now().Add(-time.Hour))
+		newWithOld := link(newWithNew, oldWithOld, oldKey)
+
+		fetched := time.Now().Add(-time.Hour)
+		before := convertCACerts([]*x509.Certificate{oldWithOld}, nil, fetched)
+		Expect(convertCACerts([]*x509.Certificate{oldWithOld}, before, time.Now()).LastFetchedTime.Time).To(Equal(fetched))
+
+		during := convertCACerts([]*x509.Certificate{oldWithOld, newWithNew, newWithOld}, before, time.Now())
+		Expect(during.LastFetchedTime.After(fetched)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Issuer health checks", func() {
+	It("should double the backoff up to the health check interval", func() {
+		spec := certmanagerv1.EstIssuerSpec{HealthCheckInterval: &metav1.Duration{Duration: time.Minute}}
+		Expect(healthCheckBackoff(spec, 1)).To(Equal(10 * time.Second))
+		Expect(healthCheckBackoff(spec, 2)).To(Equal(20 * time.Second))
+		Expect(healthCheckBackoff(spec, 3)).To(Equal(40 * time.Second))
+		Expect(healthCheckBackoff(spec, 4)).To(Equal(time.Minute))
+		Expect(healthCheckBackoff(spec, 100)).To(Equal(time.Minute))
+	})
+
+	It("should restart counting failures when the issuer changes", func() {
+		status := &certmanagerv1.EstIssuerStatus{ObservedGeneration: 1, ConsecutiveFailures: 3}
+		recordHealthCheck(status, 2, fmt.Errorf("unreachable"), time.Now())
+		Expect(status.ConsecutiveFailures).To(BeEquivalentTo(1))
+		Expect(status.ObservedGeneration).To(BeEquivalentTo(2))
+
+		recordHealthCheck(status, 2, nil, time.Now())
+		Expect(status.ConsecutiveFailures).To(BeZero())
+	})
+})
+
+var _ = Describe("Issuer conditions", func() {
+	It("should explain an issuer which is not ready with the first failing condition", func() {
+		status := &certmanagerv1.EstIssuerStatus{}
+		meta.SetStatusCondition(&status.Conditions, issuerCondition(certmanagerv1.IssuerConditionTrustAnchorValid, 1, nil,
+			"Valid", "The trust anchors are valid", "InvalidTrustAnchor"))
+		meta.SetStatusCondition(&status.Conditions, issuerCondition(certmanagerv1.IssuerConditionCredentialsValid, 1, fmt.Errorf("Referenced secret not found"),
+			"Valid", "The credentials are valid", "InvalidCredentials"))
+		meta.SetStatusCondition(&status.Conditions, issuerCondition(certmanagerv1.IssuerConditionCACertsReachable, 1, nil,
+			"Reachable", "The EST portal serves its CA certificates", "Unreachable"))
+
+		setIssuerReady(status, 1)
+		Expect(status.Ready).To(BeFalse())
+		ready := meta.FindStatusCondition(status.Conditions, certmanagerv1.IssuerConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal("InvalidCredentials"))
+		Expect(ready.Message).To(Equal("Referenced secret not found"))
+		Expect(ready.ObservedGeneration).To(BeEquivalentTo(1))
+	})
+
+	It("should only change the transition time when the status changes", func() {
+		status := &certmanagerv1.EstIssuerStatus{}
+		for _, conditionType := range []string{
+			certmanagerv1.IssuerConditionTrustAnchorValid,
+			certmanagerv1.IssuerConditionCredentialsValid,
+			certmanagerv1.IssuerConditionCACertsReachable,
+		} {
+			meta.SetStatusCondition(&status.Conditions, issuerCondition(conditionType, 1, nil, "Valid", "valid", "Invalid"))
+		}
+		setIssuerReady(status, 1)
+		transitioned := metav1.NewTime(time.Now().Add(-time.Hour))
+		meta.FindStatusCondition(status.Conditions, certmanagerv1.IssuerConditionReady).LastTransitionTime = transitioned
+
+		setIssuerReady(status, 2)
+		ready := meta.FindStatusCondition(status.Conditions, certmanagerv1.IssuerConditionReady)
+		Expect(ready.LastTransitionTime).To(Equal(transitioned))
+		Expect(ready.ObservedGeneration).To(BeEquivalentTo(2))
+
+		meta.SetStatusCondition(&status.Conditions, issuerCondition(certmanagerv1.IssuerConditionCACertsReachable, 2, fmt.Errorf("connection refused"),
+			"Reachable", "The EST portal serves its CA certificates", "Unreachable"))
+		setIssuerReady(status, 2)
+		ready = meta.FindStatusCondition(status.Conditions, certmanagerv1.IssuerConditionReady)
+		Expect(ready.Reason).To(Equal("Unreachable"))
+		Expect(ready.LastTransitionTime.After(transitioned.Time)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Issuer references", func() {
+	It("should index all Secrets and ConfigMaps an issuer refers to", func() {
+		spec := certmanagerv1.EstIssuerSpec{
+			AuthSecretName:       "credentials",
+			ClientCertSecretName: "bootstrap",
+			FullCMC:              &certmanagerv1.FullCMC{RASecretName: "ra"},
+			TLSTrustAnchor:       &certmanagerv1.CertificateSource{ConfigMapRef: &certmanagerv1.KeySelector{Name: "tls-anchor"}},
+			CABundle:             &certmanagerv1.CertificateSource{SecretRef: &certmanagerv1.KeySelector{Name: "ca-bundle"}},
+			CACertsTarget:        &certmanagerv1.CACertsTarget{Kind: "Secret", Name: "cacerts"},
+		}
+		Expect(issuerSecretNames(spec)).To(ConsistOf("credentials", "bootstrap", "ra", "ca-bundle"))
+		Expect(issuerConfigMapNames(spec)).To(ConsistOf("tls-anchor"))
+		Expect(issuerSecretNames(certmanagerv1.EstIssuerSpec{Cacert: "inline"})).To(BeEmpty())
+	})
+})
+
+var _ = Describe("EST endpoints", func() {
+	It("should append the well-known path, the label and the operation to the portal", func() {
+		spec := certmanagerv1.EstIssuerSpec{Hostname: "est.example.com", Port: 8443, Label: "arbitraryLabel1"}
+		Expect(estEndpointURL(spec, simpleEnrollPath)).To(Equal("https://est.example.com:8443/.well-known/est/arbitraryLabel1/simpleenroll"))
+
+		spec = certmanagerv1.EstIssuerSpec{Hostname: "est.example.com", WellKnown: "gateway/est/"}
+		Expect(estEndpointURL(spec, caCertsPath)).To(Equal("https://est.example.com/gateway/est/cacerts"))
+	})
+
+	It("should build the endpoints from the url and the paths of the operations", func() {
+		spec := certmanagerv1.EstIssuerSpec{
+			URL:   "https://est-gateway.pki.svc:8443/tenant-a",
+			Label: "arbitraryLabel1",
+			Paths: &certmanagerv1.EstOperationPaths{SimpleEnroll: "/enroll"},
+		}
+		Expect(estEndpointURL(spec, simpleEnrollPath)).To(Equal("https://est-gateway.pki.svc:8443/tenant-a/enroll"))
+		Expect(estEndpointURL(spec, simpleReenrollPath)).To(Equal("https://est-gateway.pki.svc:8443/tenant-a/.well-known/est/arbitraryLabel1/simplereenroll"))
+	})
+
+	It("should reject invalid combinations of settings", func() {
+		Expect(validateIssuerSpec(certmanagerv1.EstIssuerSpec{URL: "https://est.example.com/est", WellKnown: "/"})).To(Succeed())
+
+		err := validateIssuerSpec(certmanagerv1.EstIssuerSpec{URL: "https://est.example.com", Hostname: "est.example.com", Port: 443})
+		Expect(err).To(MatchError(ContainSubstring("spec.hostname: Forbidden")))
+		Expect(err).To(MatchError(ContainSubstring("spec.port: Forbidden")))
+		Expect(err).To(BeAssignableToTypeOf(&issuerConfigError{}))
+		Expect(err.(*issuerConfigError).reason).To(Equal("InvalidConfiguration"))
+
+		Expect(validateIssuerSpec(certmanagerv1.EstIssuerSpec{})).To(MatchError(ContainSubstring("spec.hostname: Required")))
+		Expect(validateIssuerSpec(certmanagerv1.EstIssuerSpec{URL: "ftp://est.example.com"})).To(MatchError(ContainSubstring("spec.url: Unsupported value")))
+		Expect(validateIssuerSpec(certmanagerv1.EstIssuerSpec{URL: "https://est.example.com:70000"})).To(MatchError(ContainSubstring("port between 1 and 65535")))
+		Expect(validateIssuerSpec(certmanagerv1.EstIssuerSpec{URL: "http://est.example.com", AuthSecretName: "credentials"})).To(
+			MatchError(ContainSubstring(`spec.url: Unsupported value: "http"`)))
+		Expect(validateIssuerSpec(certmanagerv1.EstIssuerSpec{
+			Hostname: "est.example.com",
+			Paths:    &certmanagerv1.EstOperationPaths{CACerts: "/cacerts?tenant=a"},
+		})).To(MatchError(ContainSubstring("spec.paths.cacerts: Invalid value")))
+	})
+})
@@ -17,182 +17,1150 @@ limitations under the License.
 package controller
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	certManagerApi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	"go.mozilla.org/pkcs7"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
-	"net/http"
+	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	certManagerApi "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
 	"github.com/go-logr/logr"
 	certmanagerv1 "github.com/jquad-group/est-operator/api/v1"
 )
 
+const (
+	estWellKnownPath   = certmanagerv1.DefaultWellKnownPath
+	caCertsPath        = "cacerts"
+	simpleEnrollPath   = "simpleenroll"
+	simpleReenrollPath = "simplereenroll"
+	serverKeyGenPath   = "serverkeygen"
+	csrAttrsPath       = "csrattrs"
+	mimeTypePKCS10     = "application/pkcs10"
+	mimeTypePKCS7      = "application/pkcs7-mime"
+	mimeTypePKCS8      = "application/pkcs8"
+	mimeTypeCSRAttrs   = "application/csrattrs"
+	mimeTypeMultipart  = "multipart/mixed"
+	defaultRetryAfter  = 60 * time.Second
+
+	defaultPendingTimeout = 24 * time.Hour
+
+	// estOrderIssuerRefField indexes EstOrders by the kind and name of their issuer.
+	estOrderIssuerRefField = ".spec.issuerRef"
+)
+
 // EstOrderReconciler reconciles a EstOrder object
 type EstOrderReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// ClusterResourceNamespace is the namespace in which the Secrets referenced by ClusterEstIssuers are looked up.
+	ClusterResourceNamespace string
+	// CompletedRetention is the period completed EstOrders are kept for before they are deleted. Zero keeps them
+	// until they are garbage collected with their CertificateRequest. EstOrders with server-side key generation
+	// are always kept.
+	CompletedRetention time.Duration
 }
 
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estorders,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estorders/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estorders/finalizers,verbs=update
+//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=estissuers,verbs=get;list;watch
+//+kubebuilder:rbac:groups=certmanager.jquad.rocks,resources=clusterestissuers,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificaterequests,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the EstOrder object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// The EstOrder's PKCS#10 request is submitted to the /simpleenroll endpoint of the
+// referenced issuer using its credentials, or for a renewal to the /simplereenroll
+// endpoint using the certificate being renewed, and the returned certificate is
+// stored in the EstOrder status. With server-side key generation, the request is
+// submitted to the /serverkeygen endpoint and the generated key is written together
+// with the certificate to a Secret. Issuers configured for Full CMC receive the request
+// at the /fullcmc endpoint, signed by their RA.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.2/pkg/reconcile
 func (r *EstOrderReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("estorder", req.NamespacedName)
 
-	var certificateRequest certManagerApi.CertificateRequest
-	if err := r.Get(ctx, req.NamespacedName, &certificateRequest); err != nil {
+	var estOrder certmanagerv1.EstOrder
+	if err := r.Get(ctx, req.NamespacedName, &estOrder); err != nil {
+		log.Error(err, "unable to fetch ESTOrder")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// check if the referenced issuer in the certificate requests is ready
-	var issuer certmanagerv1.EstIssuer
-	if err := r.Get(ctx, types.NamespacedName{Name: certificateRequest.Spec.IssuerRef.Name, Namespace: certificateRequest.Namespace}, &issuer); err != nil {
-		return ctrl.Result{}, err
+	// the certificate has already been issued or the order failed permanently, never enroll twice
+	if isEstOrderFinished(&estOrder) {
+		return r.retainEstOrder(ctx, &estOrder)
 	}
-	if !issuer.Status.Ready {
-		return ctrl.Result{}, fmt.Errorf("issuer %s is not ready", issuer.Name)
+
+	// a deferred request is polled at the time indicated by the EST portal and a failed request is only sent again
+	// once its backoff expired, also when the EstOrder is reconciled earlier or after a restart of the operator
+	if estOrder.Status.NextRetryTime != nil {
+		if wait := time.Until(estOrder.Status.NextRetryTime.Time); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
 	}
 
-	// create EstOrder
-	var estOrder certmanagerv1.EstOrder
-	if err := r.Get(ctx, req.NamespacedName, &estOrder); err != nil {
-		log.Error(err, "unable to fetch ESTOrder")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+	// the response to a request sent before a restart is unknown, the EST portal may be processing it already,
+	// so it is polled after the retry interval by sending the same request as described in RFC 7030 Sec. 4.2.3
+	polling := estOrder.Status.Phase == certmanagerv1.EstOrderPhaseAccepted
+	if estOrder.Status.Phase == certmanagerv1.EstOrderPhaseSubmitted && estOrder.Status.SubmittedTime != nil {
+		if wait := time.Until(estOrder.Status.SubmittedTime.Add(defaultRetryAfter)); wait > 0 {
+			log.Info("Response to the submitted request is unknown, polling it later", "retryAfter", wait)
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+		polling = true
 	}
 
-	/*
-		issuer, err := getIssuerFromResource(r.Client, estOrder.Spec.IssuerRef, estOrder.Namespace)
+	patchOptions := &client.PatchOptions{
+		FieldManager: "estorder-controller",
+		Force:        pointer.Bool(true),
+	}
+
+	subPatchOptions := &client.SubResourcePatchOptions{
+		PatchOptions: *patchOptions,
+	}
+
+	// updateStatus moves the EstOrder into the given phase and applies its status. The patch is built anew for every
+	// apply, as the response of the apiserver is decoded into it, and applying its managedFields again is rejected.
+	updateStatus := func(phase certmanagerv1.EstOrderPhase, message string) error {
+		setEstOrderPhase(&estOrder, phase, message)
+		patch := &unstructured.Unstructured{}
+		patch.SetGroupVersionKind(schema.GroupVersionKind{
+			Group:   certmanagerv1.GroupVersion.Group,
+			Version: certmanagerv1.GroupVersion.Version,
+			Kind:    "EstOrder",
+		})
+		patch.SetNamespace(estOrder.GetNamespace())
+		patch.SetName(estOrder.GetName())
+		patch.UnstructuredContent()["status"] = estOrder.Status
+		return r.Status().Patch(ctx, patch, client.Apply, subPatchOptions)
+	}
+
+	// check if the referenced issuer is ready
+	issuer, err := getIssuerFromResource(ctx, r.Client, estOrder.Spec.IssuerRef, estOrder.Namespace)
+	if isUnsupportedIssuerRef(err) {
+		estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		estOrder.Status.FailureMessage = fmt.Sprintf("invalid issuer reference: %v", err)
+		return ctrl.Result{}, updateStatus(certmanagerv1.EstOrderPhaseFailed, estOrder.Status.FailureMessage)
+	}
+	if err != nil {
+		err = fmt.Errorf("unable to get issuer: %w", err)
+		return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+	}
+	if !issuer.GetStatus().Ready {
+		err = fmt.Errorf("%s %s is not ready", estOrder.Spec.IssuerRef.Kind, issuer.GetName())
+		return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+	}
+
+	// give up on requests the EST portal deferred for longer than the issuer allows
+	deadline := pendingDeadline(&estOrder, *issuer.GetSpec())
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		estOrder.Status.FailureMessage = fmt.Sprintf("request was not issued within the pending timeout of %s", pendingTimeout(*issuer.GetSpec()))
+		estOrder.Status.NextRetryTime = nil
+		return ctrl.Result{}, updateStatus(certmanagerv1.EstOrderPhaseFailed, estOrder.Status.FailureMessage)
+	}
+
+	trust, err := loadIssuerTrust(ctx, r.Client, *issuer.GetSpec(), issuer.GetSecretNamespace(r.ClusterResourceNamespace))
+	if err != nil {
+		err = fmt.Errorf("unable to load trust anchors of the issuer: %w", err)
+		return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+	}
+
+	csr, err := decodeCertificateRequest(estOrder.Spec.Request)
+	if err != nil {
+		// the request will never become valid, fail the order right away
+		estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		estOrder.Status.FailureMessage = fmt.Sprintf("unable to decode request: %v", err)
+		return ctrl.Result{}, updateStatus(certmanagerv1.EstOrderPhaseFailed, estOrder.Status.FailureMessage)
+	}
+
+	var httpClient *http.Client
+	var decryptionKey, raCert *tls.Certificate
+	operationPath := simpleEnrollPath
+	accept := mimeTypePKCS7
+	switch {
+	case estOrder.Spec.ServerKeyGen != nil:
+		// the EST portal encrypts the generated key for this key pair, if configured
+		if name := estOrder.Spec.ServerKeyGen.DecryptionSecretName; name != "" {
+			secret, err := getSecretFromResource(ctx, r.Client, name, estOrder.Namespace)
+			if err != nil {
+				return ctrl.Result{}, fmt.Errorf("unable to get decryption secret: %w", err)
+			}
+			cert, err := loadClientCertificate(secret)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			decryptionKey = &cert
+		}
+		operationPath = serverKeyGenPath
+		accept = mimeTypeMultipart
+		estOrder.Status.Operation = certmanagerv1.EstOrderOperationServerKeyGen
+	case issuer.GetSpec().FullCMC != nil:
+		// the request is signed by the RA of the issuer, which is trusted by the EST portal to vouch for requesters
+		secret, err := getSecretFromResource(ctx, r.Client, issuer.GetSpec().FullCMC.RASecretName, issuer.GetSecretNamespace(r.ClusterResourceNamespace))
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to get RA secret: %w", err)
+		}
+		cert, err := loadClientCertificate(secret)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		raCert = &cert
+		operationPath = fullCMCPath
+		estOrder.Status.Operation = certmanagerv1.EstOrderOperationFullCMC
+	case estOrder.Spec.Renewal && estOrder.Spec.CertificateSecretName != "" && estOrder.Status.Operation != certmanagerv1.EstOrderOperationSimpleEnroll:
+		// a renewal is authenticated with the certificate being renewed, unless it is unusable or has been rejected before
+		httpClient, err = createReenrollClient(ctx, r.Client, estOrder.Spec.CertificateSecretName, estOrder.Namespace, trust.tlsAnchor)
+		if err != nil {
+			estOrder.Status.OperationMessage = fmt.Sprintf("unable to reenroll, falling back to simpleenroll: %v", err)
+			log.Info("Falling back to simpleenroll", "reason", err.Error())
+		} else {
+			operationPath = simpleReenrollPath
+			estOrder.Status.Operation = certmanagerv1.EstOrderOperationSimpleReenroll
+		}
+	}
+	if httpClient == nil {
+		httpClient, err = createIssuerClient(ctx, r.Client, *issuer.GetSpec(), issuer.GetSecretNamespace(r.ClusterResourceNamespace), trust.tlsAnchor)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to get issuer: %w", err)
+			return ctrl.Result{}, fmt.Errorf("unable to create EST client: %w", err)
 		}
+		if operationPath == simpleEnrollPath {
+			estOrder.Status.Operation = certmanagerv1.EstOrderOperationSimpleEnroll
+		}
+	}
 
-		secret, err := getSecretFromResource(r.Client, issuer.Spec.AuthSecretName, issuer.Namespace)
+	body, contentType := csr.Raw, mimeTypePKCS10
+	if raCert != nil {
+		// a request the EST portal left pending is polled with its token instead of being submitted again
+		if estOrder.Status.CMC != nil && len(estOrder.Status.CMC.PendToken) > 0 {
+			body, err = buildCMCQueryPending(estOrder.Status.CMC.PendToken, *raCert)
+		} else {
+			body, err = buildFullCMCRequest(csr, *raCert)
+		}
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to get secret: %w", err)
+			return ctrl.Result{}, fmt.Errorf("unable to create CMC request: %w", err)
+		}
+		contentType = mimeTypePKCS7 + "; smime-type=CMC-request"
+		accept = mimeTypePKCS7
+	}
+
+	reqURL, err := estEndpointURL(*issuer.GetSpec(), operationPath)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, strings.NewReader(base64.StdEncoding.EncodeToString(body)))
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", contentType)
+	httpReq.Header.Set("Content-Transfer-Encoding", "base64")
+	httpReq.Header.Set("Accept", accept)
+
+	// record the submission before sending the request
+	message := fmt.Sprintf("Request submitted to %s", reqURL)
+	if polling {
+		message = fmt.Sprintf("Request polled at %s", reqURL)
+	}
+	estOrder.Status.Attempts++
+	estOrder.Status.NextRetryTime = nil
+	estOrder.Status.SubmittedTime = &metav1.Time{Time: time.Now()}
+	if err := updateStatus(certmanagerv1.EstOrderPhaseSubmitted, message); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		estOrder.Status.LastHTTPStatusCode = 0
+		return failOrRetryEstOrder(&estOrder, newESTTransportError(err), time.Now(), updateStatus)
+	}
+	defer resp.Body.Close()
+
+	log.Info(fmt.Sprintf("EST order response: %d %s", resp.StatusCode, resp.Status))
+	estOrder.Status.LastHTTPStatusCode = resp.StatusCode
+	if resp.StatusCode < 300 {
+		estOrder.Status.ConsecutiveFailures = 0
+	}
+
+	switch {
+	case estOrder.Status.Operation == certmanagerv1.EstOrderOperationFullCMC && isCMCResponse(resp):
+		// the outcome of a Full CMC request is reported in the CMC response, also along with an HTTP error
+		return r.completeFullCMC(ctx, &estOrder, *issuer.GetSpec(), csr, trust.caBundle, resp, updateStatus)
+	case estOrder.Status.Operation == certmanagerv1.EstOrderOperationSimpleReenroll &&
+		(resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden):
+		// the portal does not accept the certificate being renewed, enroll with the credentials of the issuer instead
+		estOrder.Status.Operation = certmanagerv1.EstOrderOperationSimpleEnroll
+		estOrder.Status.OperationMessage = fmt.Sprintf("simplereenroll rejected, falling back to simpleenroll: %s", readErrorResponse(resp))
+		if err := updateStatus(certmanagerv1.EstOrderPhasePending, estOrder.Status.OperationMessage); err != nil {
+			return ctrl.Result{}, err
 		}
+		log.Info("Falling back to simpleenroll", "reason", estOrder.Status.OperationMessage)
+		return ctrl.Result{Requeue: true}, nil
+	case resp.StatusCode == http.StatusAccepted:
+		now := time.Now()
+		retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+		if !ok {
+			retryAfter = defaultRetryAfter
+		}
+		retryAfter = deferEstOrder(&estOrder, *issuer.GetSpec(), now, retryAfter)
+		if err := updateStatus(certmanagerv1.EstOrderPhaseAccepted, fmt.Sprintf("Request accepted by the EST portal, retrying at %s", estOrder.Status.NextRetryTime.UTC().Format(time.RFC3339))); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	case resp.StatusCode != http.StatusOK:
+		estErr := newESTResponseError(resp, time.Now())
+		if !estErr.retryable() {
+			log.Info("EST order failed permanently", "reason", estErr.Error())
+		}
+		return failOrRetryEstOrder(&estOrder, estErr, time.Now(), updateStatus)
+	}
 
-		certReq, err := getOwnerByKind(r.Client, &estOrder.ObjectMeta, "CertificateRequest")
+	if estOrder.Spec.ServerKeyGen != nil {
+		return r.completeServerKeyGen(ctx, &estOrder, resp, decryptionKey, trust.caBundle, updateStatus)
+	}
+
+	certs, err := readCertsResponse(resp.Body)
+	if err != nil {
+		err = fmt.Errorf("unable to read certificate response: %w", err)
+		return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+	}
+
+	leaf, chain, err := splitIssuedCertificate(certs, csr.PublicKey)
+	if err == nil {
+		err = verifyIssuedCertificate(leaf, chain, trust.caBundle)
+	}
+	if err != nil {
+		estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		estOrder.Status.FailureMessage = err.Error()
+		return ctrl.Result{}, updateStatus(certmanagerv1.EstOrderPhaseFailed, estOrder.Status.FailureMessage)
+	}
+	setIssuedCertificate(&estOrder, leaf, chain)
+	if err := updateStatus(certmanagerv1.EstOrderPhaseIssued, fmt.Sprintf("Certificate %s issued", estOrder.Status.SerialNumber)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Successfully enrolled certificate", "serialNumber", estOrder.Status.SerialNumber)
+	return ctrl.Result{}, nil
+}
+
+// completeServerKeyGen reads the generated private key and the issued certificate from the response of the
+// EST portal, and writes them to the Secret of the EstOrder before it is marked as issued.
+func (r *EstOrderReconciler) completeServerKeyGen(ctx context.Context, estOrder *certmanagerv1.EstOrder, resp *http.Response, decryptionKey *tls.Certificate, caBundle *x509.CertPool,
+	updateStatus func(certmanagerv1.EstOrderPhase, string) error) (ctrl.Result, error) {
+	certs, keyDER, err := readServerKeyGenResponse(resp, decryptionKey)
+	if err != nil {
+		err = fmt.Errorf("unable to read server-side key generation response: %w", err)
+		return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		err = fmt.Errorf("unable to parse generated private key: %w", err)
+		return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		err = fmt.Errorf("unsupported generated private key of type %T", key)
+		return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+	}
+
+	leaf, chain, err := splitIssuedCertificate(certs, signer.Public())
+	if err == nil {
+		err = verifyIssuedCertificate(leaf, chain, caBundle)
+	}
+	if err != nil {
+		estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		estOrder.Status.FailureMessage = err.Error()
+		return ctrl.Result{}, updateStatus(certmanagerv1.EstOrderPhaseFailed, estOrder.Status.FailureMessage)
+	}
+	setIssuedCertificate(estOrder, leaf, chain)
+
+	certificate, ca, err := buildCertificateChain(estOrder.Status)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      estOrder.Spec.ServerKeyGen.SecretName,
+			Namespace: estOrder.Namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certificate,
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+			"ca.crt":                ca,
+		},
+	}
+	if err := ctrl.SetControllerReference(estOrder, secret, r.Scheme); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.Patch(ctx, secret, client.Apply, client.FieldOwner("estorder-controller"), client.ForceOwnership); err != nil {
+		return ctrl.Result{}, fmt.Errorf("unable to write secret %s: %w", secret.Name, err)
+	}
+
+	if err := updateStatus(certmanagerv1.EstOrderPhaseIssued, fmt.Sprintf("Certificate %s and its private key written to secret %s", estOrder.Status.SerialNumber, secret.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// completeFullCMC interprets the CMC response of the EST portal. Issued certificates are stored in the EstOrder
+// status, a pending request is polled at the time indicated by the EST portal, and a failed one fails the order.
+func (r *EstOrderReconciler) completeFullCMC(ctx context.Context, estOrder *certmanagerv1.EstOrder, spec certmanagerv1.EstIssuerSpec, csr *x509.CertificateRequest,
+	caBundle *x509.CertPool, resp *http.Response, updateStatus func(certmanagerv1.EstOrderPhase, string) error) (ctrl.Result, error) {
+	der, err := readBase64Body(resp.Body)
+	if err == nil {
+		var cmc *cmcResponse
+		if cmc, err = parseFullCMCResponse(der); err == nil {
+			return r.applyFullCMCResponse(ctx, estOrder, spec, csr, caBundle, cmc, updateStatus)
+		}
+	}
+	err = fmt.Errorf("unable to read CMC response: %w", err)
+	return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+}
+
+// applyFullCMCResponse moves the EstOrder into the phase matching the status of the CMC response.
+func (r *EstOrderReconciler) applyFullCMCResponse(ctx context.Context, estOrder *certmanagerv1.EstOrder, spec certmanagerv1.EstIssuerSpec, csr *x509.CertificateRequest,
+	caBundle *x509.CertPool, cmc *cmcResponse, updateStatus func(certmanagerv1.EstOrderPhase, string) error) (ctrl.Result, error) {
+	log := r.Log.WithValues("estorder", client.ObjectKeyFromObject(estOrder))
+	estOrder.Status.CMC = cmc.Status.DeepCopy()
+
+	switch cmc.statusCode {
+	case cmcStatusSuccess:
+		if len(cmc.Certificates) == 0 {
+			err := errors.New("CMC response contains no certificate")
+			return ctrl.Result{}, errors.Join(err, updateStatus(certmanagerv1.EstOrderPhasePending, err.Error()))
+		}
+		leaf, chain, err := splitIssuedCertificate(cmc.Certificates, csr.PublicKey)
+		if err == nil {
+			err = verifyIssuedCertificate(leaf, chain, caBundle)
+		}
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to get certificate request: %w", err)
+			estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+			estOrder.Status.FailureMessage = err.Error()
+			return ctrl.Result{}, updateStatus(certmanagerv1.EstOrderPhaseFailed, estOrder.Status.FailureMessage)
+		}
+		setIssuedCertificate(estOrder, leaf, chain)
+		if err := updateStatus(certmanagerv1.EstOrderPhaseIssued, fmt.Sprintf("Certificate %s issued", estOrder.Status.SerialNumber)); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Successfully enrolled certificate", "serialNumber", estOrder.Status.SerialNumber)
+		return ctrl.Result{}, nil
+	case cmcStatusPending:
+		now := time.Now()
+		retryAfter := defaultRetryAfter
+		if !cmc.PendTime.IsZero() {
+			retryAfter = cmc.PendTime.Sub(now)
+		}
+		retryAfter = deferEstOrder(estOrder, spec, now, retryAfter)
+		if err := updateStatus(certmanagerv1.EstOrderPhaseAccepted, fmt.Sprintf("Request pending at the EST portal, retrying at %s", estOrder.Status.NextRetryTime.UTC().Format(time.RFC3339))); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	default:
+		// failed, which the CA denies the request with, and statuses requiring interactions this operator does not support
+		estOrder.Status.FailureTime = &metav1.Time{Time: time.Now()}
+		estOrder.Status.FailureMessage = fmt.Sprintf("request problem: %s", cmc.message())
+		phase := certmanagerv1.EstOrderPhaseFailed
+		if cmc.statusCode == cmcStatusFailed {
+			phase = certmanagerv1.EstOrderPhaseDenied
+		}
+		if err := updateStatus(phase, estOrder.Status.FailureMessage); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("EST order failed permanently", "reason", estOrder.Status.FailureMessage)
+		return ctrl.Result{}, nil
+	}
+}
+
+// verifyIssuedCertificate verifies that the issued certificate chains to the CA bundle of the issuer, if any,
+// using the other certificates of the response as intermediates.
+func verifyIssuedCertificate(leaf *x509.Certificate, chain []*x509.Certificate, caBundle *x509.CertPool) error {
+	if caBundle == nil {
+		return nil
+	}
+	if err := verifyCertificateChain(leaf, chain, caBundle); err != nil {
+		return fmt.Errorf("issued certificate does not chain to the CA bundle of the issuer: %w", err)
+	}
+	return nil
+}
+
+// setIssuedCertificate stores the issued certificate and the remaining certificates of the response in the EstOrder status.
+func setIssuedCertificate(estOrder *certmanagerv1.EstOrder, leaf *x509.Certificate, chain []*x509.Certificate) {
+	estOrder.Status.Certificate = encodeCertificates([]*x509.Certificate{leaf})
+	estOrder.Status.Chain = encodeCertificates(chain)
+	estOrder.Status.SerialNumber = leaf.SerialNumber.Text(16)
+	estOrder.Status.NotBefore = &metav1.Time{Time: leaf.NotBefore}
+	estOrder.Status.NotAfter = &metav1.Time{Time: leaf.NotAfter}
+}
+
+// pendingTimeout returns the maximum time the issuer allows a deferred request to be polled.
+func pendingTimeout(spec certmanagerv1.EstIssuerSpec) time.Duration {
+	if spec.PendingTimeout != nil {
+		return spec.PendingTimeout.Duration
+	}
+	return defaultPendingTimeout
+}
+
+// pendingDeadline returns the time at which a deferred request fails, or the zero time
+// if the EST portal has not deferred the request.
+func pendingDeadline(estOrder *certmanagerv1.EstOrder, spec certmanagerv1.EstIssuerSpec) time.Time {
+	if estOrder.Status.AcceptedTime == nil {
+		return time.Time{}
+	}
+	return estOrder.Status.AcceptedTime.Add(pendingTimeout(spec))
+}
+
+// deferEstOrder records that the EST portal deferred the request and schedules the next poll after
+// the given duration, which is clamped to the pending deadline of the issuer. It returns the time to
+// wait until the next poll.
+func deferEstOrder(estOrder *certmanagerv1.EstOrder, spec certmanagerv1.EstIssuerSpec, now time.Time, retryAfter time.Duration) time.Duration {
+	if estOrder.Status.AcceptedTime == nil {
+		estOrder.Status.AcceptedTime = &metav1.Time{Time: now}
+	}
+
+	// poll a last time when the pending timeout expires instead of waiting beyond it
+	if deadline := pendingDeadline(estOrder, spec); now.Add(retryAfter).After(deadline) {
+		retryAfter = deadline.Sub(now)
+	}
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+
+	estOrder.Status.NextRetryTime = &metav1.Time{Time: now.Add(retryAfter)}
+	return retryAfter
+}
+
+// failOrRetryEstOrder fails the EstOrder for a terminal EST error, or denies it if the EST portal denied the
+// request. Otherwise it is moved back to Pending and
+// submitted again after an exponential backoff, or after the delay the EST portal asked for.
+func failOrRetryEstOrder(estOrder *certmanagerv1.EstOrder, estErr *estError, now time.Time,
+	updateStatus func(certmanagerv1.EstOrderPhase, string) error) (ctrl.Result, error) {
+	if !estErr.retryable() {
+		estOrder.Status.FailureTime = &metav1.Time{Time: now}
+		estOrder.Status.FailureMessage = estErr.Error()
+		estOrder.Status.NextRetryTime = nil
+		phase := certmanagerv1.EstOrderPhaseFailed
+		if estErr.denied() {
+			phase = certmanagerv1.EstOrderPhaseDenied
+		}
+		return ctrl.Result{}, updateStatus(phase, estOrder.Status.FailureMessage)
+	}
+
+	estOrder.Status.ConsecutiveFailures++
+	retryAfter := estErr.retryAfter
+	if retryAfter <= 0 {
+		retryAfter = estOrderBackoff(estOrder.Status.ConsecutiveFailures)
+	}
+	estOrder.Status.NextRetryTime = &metav1.Time{Time: now.Add(retryAfter)}
+	message := fmt.Sprintf("%s, retrying at %s", estErr.Error(), estOrder.Status.NextRetryTime.UTC().Format(time.RFC3339))
+	if err := updateStatus(certmanagerv1.EstOrderPhasePending, message); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: retryAfter}, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a number
+// of seconds or an HTTP-date as described in RFC 9110 Sec. 10.2.3.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// isEstOrderFinished reports whether the EstOrder reached a final phase.
+func isEstOrderFinished(estOrder *certmanagerv1.EstOrder) bool {
+	switch estOrder.Status.Phase {
+	case certmanagerv1.EstOrderPhaseIssued, certmanagerv1.EstOrderPhaseFailed, certmanagerv1.EstOrderPhaseDenied:
+		return true
+	}
+	return len(estOrder.Status.Certificate) > 0 || estOrder.Status.FailureTime != nil
+}
+
+// estOrderBecameFinished filters EstOrder events down to updates finishing the EstOrder, which starts its retention period.
+func estOrderBecameFinished() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldOrder, ok := e.ObjectOld.(*certmanagerv1.EstOrder)
+			if !ok {
+				return false
+			}
+			newOrder, ok := e.ObjectNew.(*certmanagerv1.EstOrder)
+			return ok && !isEstOrderFinished(oldOrder) && isEstOrderFinished(newOrder)
+		},
+	}
+}
+
+// retainEstOrder deletes a finished EstOrder once it has been retained for the configured period. An EstOrder
+// created for a CertificateRequest is only deleted after the request has taken over its result, as the request
+// would otherwise be enrolled again. EstOrders with server-side key generation are never deleted, as they own
+// the Secret holding the generated private key, which would be garbage collected with them.
+func (r *EstOrderReconciler) retainEstOrder(ctx context.Context, estOrder *certmanagerv1.EstOrder) (ctrl.Result, error) {
+	if r.CompletedRetention <= 0 || estOrder.Spec.ServerKeyGen != nil {
+		return ctrl.Result{}, nil
+	}
+
+	completed := estOrder.CreationTimestamp.Time
+	if estOrder.Status.CompletionTime != nil {
+		completed = estOrder.Status.CompletionTime.Time
+	}
+	if wait := time.Until(completed.Add(r.CompletedRetention)); wait > 0 {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	if owner := metav1.GetControllerOf(estOrder); owner != nil && owner.Kind == "CertificateRequest" {
+		var certificateRequest certManagerApi.CertificateRequest
+		err := r.Get(ctx, types.NamespacedName{Name: owner.Name, Namespace: estOrder.Namespace}, &certificateRequest)
+		if client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+		if err == nil && !isCertificateRequestCompleted(&certificateRequest) {
+			return ctrl.Result{RequeueAfter: r.CompletedRetention}, nil
 		}
+	}
+
+	r.Log.Info("deleting completed EstOrder", "estorder", client.ObjectKeyFromObject(estOrder), "phase", estOrder.Status.Phase)
+	return ctrl.Result{}, client.IgnoreNotFound(r.Delete(ctx, estOrder))
+}
+
+// setEstOrderPhase sets the phase of the EstOrder together with its Ready condition.
+func setEstOrderPhase(estOrder *certmanagerv1.EstOrder, phase certmanagerv1.EstOrderPhase, message string) {
+	status := metav1.ConditionFalse
+	if phase == certmanagerv1.EstOrderPhaseIssued {
+		status = metav1.ConditionTrue
+	}
+
+	switch phase {
+	case certmanagerv1.EstOrderPhaseIssued, certmanagerv1.EstOrderPhaseFailed, certmanagerv1.EstOrderPhaseDenied:
+		if estOrder.Status.CompletionTime == nil {
+			estOrder.Status.CompletionTime = &metav1.Time{Time: time.Now()}
+		}
+	}
+
+	estOrder.Status.Phase = phase
+	meta.SetStatusCondition(&estOrder.Status.Conditions, metav1.Condition{
+		Type:               certmanagerv1.EstOrderConditionReady,
+		Status:             status,
+		ObservedGeneration: estOrder.Generation,
+		Reason:             string(phase),
+		Message:            message,
+	})
+}
+
+var (
+	// errIssuerGroupNotSupported is returned for issuer references outside of the certmanager.jquad.rocks group.
+	errIssuerGroupNotSupported = errors.New("issuer group is not supported")
+	// errIssuerKindNotSupported is returned for issuer references to kinds other than EstIssuer and ClusterEstIssuer.
+	errIssuerKindNotSupported = errors.New("issuer kind is not supported")
+	// errNoIssuedCertificate is returned when none of the certificates returned by the EST portal holds the requested key.
+	errNoIssuedCertificate = errors.New("the EST portal returned no certificate for the public key of the request")
+)
+
+// getIssuerFromResource fetches the EstIssuer or ClusterEstIssuer the reference points to. An EstIssuer
+// is looked up in the given namespace. References to other groups or kinds are rejected with
+// errIssuerGroupNotSupported or errIssuerKindNotSupported, which retrying does not resolve.
+func getIssuerFromResource(ctx context.Context, c client.Client, ref certmanagerv1.IssuerRef, namespace string) (certmanagerv1.GenericIssuer, error) {
+	if ref.Group != certmanagerv1.GroupVersion.Group {
+		return nil, fmt.Errorf("%w: %q, expected %q", errIssuerGroupNotSupported, ref.Group, certmanagerv1.GroupVersion.Group)
+	}
+
+	var issuer certmanagerv1.GenericIssuer
+	key := types.NamespacedName{Name: ref.Name}
+	switch ref.Kind {
+	case certmanagerv1.EstIssuerKind:
+		issuer = &certmanagerv1.EstIssuer{}
+		key.Namespace = namespace
+	case certmanagerv1.ClusterEstIssuerKind:
+		issuer = &certmanagerv1.ClusterEstIssuer{}
+	default:
+		return nil, fmt.Errorf("%w: %q, expected %q or %q", errIssuerKindNotSupported, ref.Kind, certmanagerv1.EstIssuerKind, certmanagerv1.ClusterEstIssuerKind)
+	}
+
+	if err := c.Get(ctx, key, issuer); err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
+
+// isUnsupportedIssuerRef reports whether the error was caused by an issuer reference this operator does not serve.
+func isUnsupportedIssuerRef(err error) bool {
+	return errors.Is(err, errIssuerGroupNotSupported) || errors.Is(err, errIssuerKindNotSupported)
+}
+
+func getSecretFromResource(ctx context.Context, c client.Client, ref string, namespace string) (corev1.Secret, error) {
+	var secret corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Name: ref, Namespace: namespace}, &secret); err != nil {
+		return corev1.Secret{}, err
+	}
+	return secret, nil
+}
 
-		caCert, err := base64.StdEncoding.DecodeString(issuer.Spec.Cacert)
+// createIssuerClient creates an HTTP client for the EST portal of the issuer. The client presents the
+// bootstrap certificate if the issuer has one, and authenticates with HTTP Basic Authentication if
+// the issuer has credentials. Both Secrets are read from the given namespace.
+func createIssuerClient(ctx context.Context, c client.Client, spec certmanagerv1.EstIssuerSpec, secretNamespace string, tlsAnchor *x509.CertPool) (*http.Client, error) {
+	var clientCert *tls.Certificate
+	if spec.ClientCertSecretName != "" {
+		secret, err := getSecretFromResource(ctx, c, spec.ClientCertSecretName, secretNamespace)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to decode CA certificate: %w", err)
+			return nil, fmt.Errorf("unable to get client certificate secret: %w", err)
 		}
+		cert, err := loadClientCertificate(secret)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkCertificateValidity(cert.Leaf, time.Now()); err != nil {
+			return nil, err
+		}
+		clientCert = &cert
+	}
+
+	httpClient := createTLSClient(tlsAnchor, clientCert)
 
-		request, err := base64.StdEncoding.DecodeString(estOrder.Spec.Request)
+	if spec.AuthSecretName != "" {
+		secret, err := getSecretFromResource(ctx, c, spec.AuthSecretName, secretNamespace)
 		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("unable to decode request: %w", err)
+			return nil, fmt.Errorf("unable to get secret: %w", err)
 		}
+		username, password, err := loadBasicAuthCredentials(secret)
+		if err != nil {
+			return nil, err
+		}
+		httpClient.Transport = &basicAuthTransport{
+			username: username,
+			password: password,
+			next:     httpClient.Transport,
+		}
+	}
+	return httpClient, nil
+}
 
-		baseURL := fmt.Sprintf("https://%s:%d", issuer.Spec.Hostname, issuer.Spec.Port)
-		path := filepath.Join("/.well-known/est", issuer.Spec.Label)
+// loadBasicAuthCredentials reads the username and password of a Secret holding HTTP Basic Authentication credentials.
+func loadBasicAuthCredentials(secret corev1.Secret) (string, string, error) {
+	for _, key := range []string{corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey} {
+		if len(secret.Data[key]) == 0 {
+			return "", "", fmt.Errorf("secret %s is missing the key %q", secret.Name, key)
+		}
+	}
+	return string(secret.Data[corev1.BasicAuthUsernameKey]), string(secret.Data[corev1.BasicAuthPasswordKey]), nil
+}
 
-		var httpClient *http.Client
-		if estOrder.Spec.Renewal {
-			path = filepath.Join(path, "simplereenroll")
-			tlsSecret, err := getSecretFromResource(r.Client, certReq.Spec.SecretRef, certReq.Namespace)
-			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("request is renewal but TLS secret is missing: %w", err)
-			}
+// createReenrollClient creates an HTTP client which authenticates with the certificate being renewed,
+// read from the kubernetes.io/tls Secret with the given name.
+func createReenrollClient(ctx context.Context, c client.Client, secretName, namespace string, tlsAnchor *x509.CertPool) (*http.Client, error) {
+	secret, err := getSecretFromResource(ctx, c, secretName, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get certificate secret: %w", err)
+	}
+	cert, err := loadClientCertificate(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCertificateValidity(cert.Leaf, time.Now()); err != nil {
+		return nil, err
+	}
+	return createTLSClient(tlsAnchor, &cert), nil
+}
 
-			httpClient, err = createTLSClient(caCert, &tlsSecret)
-			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("unable to create TLS client: %w", err)
+// createTLSClient creates an HTTP client trusting the given trust anchor, or the system trust store if nil,
+// which presents the client certificate, if any, for TLS client authentication.
+func createTLSClient(tlsAnchor *x509.CertPool, clientCert *tls.Certificate) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    tlsAnchor,
+		MinVersion: tls.VersionTLS12,
+	}
+	if clientCert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+
+	return &http.Client{Transport: transport}
+}
+
+// loadClientCertificate reads the key pair of a kubernetes.io/tls Secret.
+func loadClientCertificate(secret corev1.Secret) (tls.Certificate, error) {
+	if secret.Type != corev1.SecretTypeTLS {
+		return tls.Certificate{}, fmt.Errorf("secret %s is of type %q, expected %q", secret.Name, secret.Type, corev1.SecretTypeTLS)
+	}
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if len(secret.Data[key]) == 0 {
+			return tls.Certificate{}, fmt.Errorf("secret %s is missing the key %q", secret.Name, key)
+		}
+	}
+
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("secret %s does not hold a valid key pair: %w", secret.Name, err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("secret %s does not hold a valid certificate: %w", secret.Name, err)
+	}
+	return cert, nil
+}
+
+// checkCertificateValidity returns an error if the certificate is not valid at the given time.
+func checkCertificateValidity(cert *x509.Certificate, now time.Time) error {
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("client certificate %q is not valid before %s", cert.Subject.CommonName, cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("client certificate %q expired at %s", cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// basicAuthTransport adds HTTP Basic Authentication to every request.
+type basicAuthTransport struct {
+	username string
+	password string
+	next     http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.SetBasicAuth(t.username, t.password)
+	return t.next.RoundTrip(req)
+}
+
+// estEndpointURL builds the URL of an EST operation as described in RFC 7030 Sec. 3.2.2. The well-known path of
+// the issuer, the label and the operation are appended to the base URL, unless the issuer overrides the path of the operation.
+func estEndpointURL(spec certmanagerv1.EstIssuerSpec, operation string) (string, error) {
+	endpoint, err := spec.BaseURL()
+	if err != nil {
+		return "", fmt.Errorf("invalid url of the EST portal: %w", err)
+	}
+	if operationPath := spec.Paths.Path(operation); operationPath != "" {
+		endpoint.Path = path.Join("/", endpoint.Path, operationPath)
+	} else {
+		wellKnown := spec.WellKnown
+		if wellKnown == "" {
+			wellKnown = estWellKnownPath
+		}
+		endpoint.Path = path.Join("/", endpoint.Path, wellKnown, spec.Label, operation)
+	}
+	return endpoint.String(), nil
+}
+
+// decodeCertificateRequest parses a PEM encoded PKCS#10 request.
+func decodeCertificateRequest(request []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("request is not a PEM encoded PKCS#10 certificate request")
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+// readCertsResponse decodes the base64 encoded PKCS#7 certs-only response of an
+// enrollment as described in RFC 7030 Sec. 4.2.3. The issued certificate is the first one.
+func readCertsResponse(body io.Reader) ([]*x509.Certificate, error) {
+	der, err := readBase64Body(body)
+	if err != nil {
+		return nil, err
+	}
+
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PKCS#7 structure: %w", err)
+	}
+	if len(p7.Certificates) == 0 {
+		return nil, errors.New("no certificates found in PKCS#7 structure")
+	}
+	return p7.Certificates, nil
+}
+
+// readServerKeyGenResponse reads the multipart response of a server-side key generation as described in
+// RFC 7030 Sec. 4.4.2. It returns the issued certificates and the generated private key in PKCS#8 DER
+// encoding, which is decrypted with the given key pair if the EST portal employed additional encryption.
+func readServerKeyGenResponse(resp *http.Response, decryptionKey *tls.Certificate) ([]*x509.Certificate, []byte, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed content type: %w", err)
+	}
+	if mediaType != mimeTypeMultipart {
+		return nil, nil, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+
+	var certs []*x509.Certificate
+	var key []byte
+	parts := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		partType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed content type of part: %w", err)
+		}
+		switch {
+		case partType == mimeTypePKCS8:
+			if key, err = readBase64Body(part); err != nil {
+				return nil, nil, err
 			}
-		} else {
-			path = filepath.Join(path, "simpleenroll")
-			username, err := base64.StdEncoding.DecodeString(string(secret.Data["username"]))
+		case partType == mimeTypePKCS7 && partParams["smime-type"] == "server-generated-key":
+			der, err := readBase64Body(part)
 			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("unable to decode username: %w", err)
+				return nil, nil, err
 			}
-			password, err := base64.StdEncoding.DecodeString(string(secret.Data["password"]))
-			if err != nil {
-				return ctrl.Result{}, fmt.Errorf("unable to decode password: %w", err)
+			if key, err = unwrapServerGeneratedKey(der, decryptionKey); err != nil {
+				return nil, nil, err
 			}
-			httpClient = createBasicAuthClient(caCert, string(username), string(password))
+		case partType == mimeTypePKCS7:
+			if certs, err = readCertsResponse(part); err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("unexpected content type of part %q", partType)
 		}
+	}
 
-		reqURL := baseURL + path
-		resp, err := httpClient.Post(reqURL, "application/pkcs10", strings.NewReader(string(request)))
-		if err != nil {
-			return ctrl.Result{}, fmt.Errorf("request failed: %w", err)
-		}
-		defer resp.Body.Close()
+	if len(certs) == 0 {
+		return nil, nil, errors.New("no certificate returned")
+	}
+	if len(key) == 0 {
+		return nil, nil, errors.New("no private key returned")
+	}
+	return certs, key, nil
+}
 
-		log.Info(fmt.Sprintf("EST order response: %d %s", resp.StatusCode, resp.Status))
+// unwrapServerGeneratedKey returns the PKCS#8 private key of a server-generated-key part, which may be
+// signed by the EST portal and may be enveloped for the key pair of the requester (RFC 7030 Sec. 4.4.2).
+// Only enveloping with RSA key transport is supported.
+func unwrapServerGeneratedKey(der []byte, decryptionKey *tls.Certificate) ([]byte, error) {
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PKCS#7 structure of the private key: %w", err)
+	}
 
-		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
-			return ctrl.Result{RequeueAfter: 10 * time.Minute}, fmt.Errorf("request problem: %s", resp.Status)
-		} else if resp.StatusCode >= 500 {
-			return ctrl.Result{RequeueAfter: 10 * time.Minute}, fmt.Errorf("server problem: %s", resp.Status)
-		} else if resp.StatusCode == http.StatusAccepted {
-			retryAfter := resp.Header.Get("Retry-After")
-			retryDuration, err := time.ParseDuration(retryAfter)
-			if err != nil {
-				retryAfterSeconds, _ := strconv.Atoi(retryAfter)
-				retryDuration = time.Duration(retryAfterSeconds) * time.Second
-			}
-			return ctrl.Result{RequeueAfter: retryDuration}, nil
-		} else if resp.StatusCode == http.StatusOK {
-			// Handle successful response
-			// Update status of certReq
+	if len(p7.Signers) > 0 {
+		if err := p7.Verify(); err != nil {
+			return nil, fmt.Errorf("invalid signature of the private key: %w", err)
+		}
+		if _, err := x509.ParsePKCS8PrivateKey(p7.Content); err == nil {
+			return p7.Content, nil
+		}
+		if p7, err = pkcs7.Parse(p7.Content); err != nil {
+			return nil, fmt.Errorf("malformed PKCS#7 structure of the signed private key: %w", err)
 		}
+	}
 
-	*/
-	return ctrl.Result{}, nil
+	if decryptionKey == nil {
+		return nil, errors.New("the private key is encrypted, but no decryption secret is configured")
+	}
+	key, err := p7.Decrypt(decryptionKey.Leaf, decryptionKey.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the private key: %w", err)
+	}
+	return key, nil
 }
 
-func getIssuerFromResource(client client.Client, ref certmanagerv1.IssuerRef, namespace string) (certmanagerv1.EstIssuer, error) {
-	// Implement the function to get the issuer
-	return certmanagerv1.EstIssuer{}, nil
+// readBase64Body decodes a base64 encoded body, which may be split across several lines.
+func readBase64Body(body io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(data), nil)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 encoding: %w", err)
+	}
+	return der, nil
 }
 
-func getSecretFromResource(client client.Client, ref string, namespace string) (corev1.Secret, error) {
-	// Implement the function to get the secret
-	return corev1.Secret{}, nil
+// readErrorResponse returns the human readable error message of an EST response,
+// which RFC 7030 Sec. 4.2.3 allows to be sent as plain text.
+func readErrorResponse(resp *http.Response) string {
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return resp.Status
+	}
+	return fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
 }
 
-func getOwnerByKind(client client.Client, owner metav1.Object, kind string) (certManagerApi.CertificateRequest, error) {
-	// Implement the function to get the owner by kind
-	return certManagerApi.CertificateRequest{}, nil
+// splitIssuedCertificate separates the certificate issued for the request from the
+// remaining certificates of the response, since RFC 7030 does not mandate an order.
+// The issued certificate is the one holding the public key of the request.
+func splitIssuedCertificate(certs []*x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, []*x509.Certificate, error) {
+	for i, cert := range certs {
+		if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && pub.Equal(publicKey) {
+			chain := make([]*x509.Certificate, 0, len(certs)-1)
+			chain = append(chain, certs[:i]...)
+			chain = append(chain, certs[i+1:]...)
+			return cert, chain, nil
+		}
+	}
+	return nil, nil, errNoIssuedCertificate
 }
 
-func createTLSClient(caCert []byte, tlsSecret *corev1.Secret) (*http.Client, error) {
-	// Implement the function to create an HTTP client with TLS config
-	return nil, nil
+// parseCertificates parses all PEM encoded certificates.
+func parseCertificates(pemData []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(pemData); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
 }
 
-func createBasicAuthClient(caCert []byte, username, password string) *http.Client {
-	// Implement the function to create an HTTP client with Basic Auth
-	return nil
+// encodeCertificates encodes the certificates in PEM encoding.
+func encodeCertificates(certs []*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// issuerRefKey identifies an issuer by its kind and name in the field index of EstOrders.
+func issuerRefKey(kind, name string) string {
+	return kind + "/" + name
+}
+
+// SetupWithManager sets up the controller with the Manager. Pending EstOrders are retried when their issuer
+// becomes ready, and when a Secret or ConfigMap of their issuer changes, which relies on the field indexes
+// registered by the issuer controllers.
 func (r *EstOrderReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &certmanagerv1.EstOrder{}, estOrderIssuerRefField, func(rawObj client.Object) []string {
+		ref := rawObj.(*certmanagerv1.EstOrder).Spec.IssuerRef
+		return []string{issuerRefKey(ref.Kind, ref.Name)}
+	}); err != nil {
+		return err
+	}
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&certmanagerv1.EstOrder{},
-			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+			builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, estOrderBecameFinished()))).
+		Watches(
+			&certmanagerv1.EstIssuer{},
+			handler.EnqueueRequestsFromMapFunc(r.findPendingEstOrdersForIssuer),
+			builder.WithPredicates(issuerBecameReady()),
+		).
+		Watches(
+			&certmanagerv1.ClusterEstIssuer{},
+			handler.EnqueueRequestsFromMapFunc(r.findPendingEstOrdersForIssuer),
+			builder.WithPredicates(issuerBecameReady()),
+		).
+		Watches(
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.findPendingEstOrdersReferencing(issuerSecretNamesField)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+		).
+		Watches(
+			&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(r.findPendingEstOrdersReferencing(issuerConfigMapNamesField)),
+			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+		).
 		Complete(r)
 }
+
+// findPendingEstOrdersReferencing maps a Secret or ConfigMap to the pending EstOrders of the issuers referencing
+// it in the given field index, so that requests waiting for rotated credentials or trust anchors are retried.
+func (r *EstOrderReconciler) findPendingEstOrdersReferencing(field string) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		estIssuers, err := findEstIssuersReferencing(ctx, r.Client, obj, field)
+		if err != nil {
+			r.Log.Error(err, "unable to list EstIssuers", "field", field, "name", obj.GetName())
+			return nil
+		}
+		clusterIssuers, err := findClusterEstIssuersReferencing(ctx, r.Client, obj, field, r.ClusterResourceNamespace)
+		if err != nil {
+			r.Log.Error(err, "unable to list ClusterEstIssuers", "field", field, "name", obj.GetName())
+			return nil
+		}
+
+		var requests []reconcile.Request
+		for _, issuer := range estIssuers {
+			requests = append(requests, r.findPendingEstOrdersForIssuer(ctx, &issuer)...)
+		}
+		for _, issuer := range clusterIssuers {
+			requests = append(requests, r.findPendingEstOrdersForIssuer(ctx, &issuer)...)
+		}
+		return requests
+	}
+}
+
+// findPendingEstOrdersForIssuer maps an EstIssuer or ClusterEstIssuer to its EstOrders which are not finished yet.
+func (r *EstOrderReconciler) findPendingEstOrdersForIssuer(ctx context.Context, issuer client.Object) []reconcile.Request {
+	kind, opts := issuerListOptions(issuer)
+	var estOrders certmanagerv1.EstOrderList
+	opts = append(opts, client.MatchingFields{estOrderIssuerRefField: issuerRefKey(kind, issuer.GetName())})
+	if err := r.List(ctx, &estOrders, opts...); err != nil {
+		r.Log.Error(err, "unable to list EstOrders", "issuer", issuerRefKey(kind, issuer.GetName()))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, estOrder := range estOrders.Items {
+		if !isEstOrderFinished(&estOrder) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&estOrder)})
+		}
+	}
+	return requests
+}
@@ -18,11 +18,24 @@ package controller
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -33,16 +46,52 @@ import (
 var _ = Describe("EstOrder Controller", func() {
 	Context("When reconciling a resource", func() {
 		const resourceName = "test-resource"
+		const issuerName = "test-estorder-issuer"
+		const secretName = "test-estorder-credentials"
 
 		ctx := context.Background()
 
 		typeNamespacedName := types.NamespacedName{
 			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
+			Namespace: "default",
 		}
 		estorder := &certmanagerv1.EstOrder{}
 
+		var estServer *fakeESTServer
+
 		BeforeEach(func() {
+			estServer = newFakeESTServer("estuser", "estpwd")
+
+			By("creating the credentials and the issuer the EstOrder refers to")
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      secretName,
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeBasicAuth,
+				Data: map[string][]byte{
+					corev1.BasicAuthUsernameKey: []byte("estuser"),
+					corev1.BasicAuthPasswordKey: []byte("estpwd"),
+				},
+			}
+			Expect(k8sClient.Create(ctx, secret)).To(Succeed())
+
+			issuer := &certmanagerv1.EstIssuer{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      issuerName,
+					Namespace: "default",
+				},
+				Spec: certmanagerv1.EstIssuerSpec{
+					Hostname:       estServer.hostname(),
+					Port:           estServer.port(),
+					Cacert:         estServer.trustAnchor(),
+					AuthSecretName: secretName,
+				},
+			}
+			Expect(k8sClient.Create(ctx, issuer)).To(Succeed())
+			issuer.Status.Ready = true
+			Expect(k8sClient.Status().Update(ctx, issuer)).To(Succeed())
+
 			By("creating the custom resource for the Kind EstOrder")
 			err := k8sClient.Get(ctx, typeNamespacedName, estorder)
 			if err != nil && errors.IsNotFound(err) {
@@ -51,20 +100,29 @@ var _ = Describe("EstOrder Controller", func() {
 						Name:      resourceName,
 						Namespace: "default",
 					},
-					// TODO(user): Specify other spec details if needed.
+					Spec: certmanagerv1.EstOrderSpec{
+						IssuerRef: certmanagerv1.IssuerRef{
+							Kind:  "EstIssuer",
+							Group: certmanagerv1.GroupVersion.Group,
+							Name:  issuerName,
+						},
+						Request: newTestCertificateRequest("test-est.jquad.rocks"),
+					},
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
 		})
 
 		AfterEach(func() {
-			// TODO(user): Cleanup logic after each test, like removing the resource instance.
 			resource := &certmanagerv1.EstOrder{}
 			err := k8sClient.Get(ctx, typeNamespacedName, resource)
 			Expect(err).NotTo(HaveOccurred())
 
 			By("Cleanup the specific resource instance EstOrder")
 			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &certmanagerv1.EstIssuer{ObjectMeta: metav1.ObjectMeta{Name: issuerName, Namespace: "default"}})).To(Succeed())
+			Expect(k8sClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: "default"}})).To(Succeed())
+			estServer.Close()
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
@@ -77,8 +135,681 @@ var _ = Describe("EstOrder Controller", func() {
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+
+			By("Checking the issued certificate is stored in the status")
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			block, _ := pem.Decode(resource.Status.Certificate)
+			Expect(block).NotTo(BeNil())
+			cert, err := x509.ParseCertificate(block.Bytes)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cert.Subject.CommonName).To(Equal("test-est.jquad.rocks"))
+			Expect(cert.CheckSignatureFrom(estServer.caCert)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseIssued))
+			Expect(resource.Status.SerialNumber).To(Equal(cert.SerialNumber.Text(16)))
+			Expect(resource.Status.NotAfter.Time).To(BeTemporally("==", cert.NotAfter))
+			Expect(resource.Status.LastHTTPStatusCode).To(Equal(http.StatusOK))
+			Expect(resource.Status.Attempts).To(Equal(int32(1)))
+			Expect(meta.IsStatusConditionTrue(resource.Status.Conditions, certmanagerv1.EstOrderConditionReady)).To(BeTrue())
+		})
+		It("should poll a deferred request at the time indicated by the portal", func() {
+			estServer.retryAfter = "120"
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(120 * time.Second))
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseAccepted))
+			Expect(resource.Status.LastHTTPStatusCode).To(Equal(http.StatusAccepted))
+			Expect(resource.Status.AcceptedTime).NotTo(BeNil())
+			Expect(resource.Status.NextRetryTime).NotTo(BeNil())
+
+			By("Reconciling again before the retry time without submitting the request")
+			result, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 100*time.Second))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Attempts).To(Equal(int32(1)))
+		})
+		It("should poll a request whose response was never recorded instead of sending it right away", func() {
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Status.Phase = certmanagerv1.EstOrderPhaseSubmitted
+			resource.Status.Attempts = 1
+			resource.Status.SubmittedTime = &metav1.Time{Time: time.Now()}
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically("~", defaultRetryAfter, time.Second))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Attempts).To(Equal(int32(1)))
+
+			By("Polling the request once the retry interval has passed")
+			resource.Status.SubmittedTime = &metav1.Time{Time: time.Now().Add(-defaultRetryAfter)}
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseIssued))
+			Expect(resource.Status.Attempts).To(Equal(int32(2)))
+		})
+		It("should retry the order when the portal is unavailable", func() {
+			estServer.failStatus = http.StatusServiceUnavailable
+			estServer.failMessage = "CA is under maintenance"
+			estServer.retryAfter = "300"
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(300 * time.Second))
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhasePending))
+			Expect(resource.Status.ConsecutiveFailures).To(Equal(int32(1)))
+			Expect(resource.Status.FailureTime).To(BeNil())
+			condition := meta.FindStatusCondition(resource.Status.Conditions, certmanagerv1.EstOrderConditionReady)
+			Expect(condition).NotTo(BeNil())
+			Expect(condition.Message).To(ContainSubstring("CA is under maintenance"))
+			Expect(resource.Status.Attempts).To(Equal(int32(1)))
+
+			By("Reconciling again before the backoff expired without submitting the request")
+			result, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically(">", 250*time.Second))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhasePending))
+			Expect(resource.Status.Attempts).To(Equal(int32(1)))
+			Expect(resource.Status.ConsecutiveFailures).To(Equal(int32(1)))
+
+			By("Backing off exponentially without a Retry-After header")
+			resource.Status.NextRetryTime = &metav1.Time{Time: time.Now().Add(-time.Second)}
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+			estServer.failStatus = http.StatusInternalServerError
+			estServer.retryAfter = ""
+			result, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(2 * initialEstOrderBackoff))
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Attempts).To(Equal(int32(2)))
+		})
+		It("should deny the order when the portal rejects the credentials of the issuer", func() {
+			By("Changing the credentials to ones the portal does not accept")
+			secret := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: secretName, Namespace: "default"}, secret)).To(Succeed())
+			secret.Data[corev1.BasicAuthPasswordKey] = []byte("wrong")
+			Expect(k8sClient.Update(ctx, secret)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Certificate).To(BeEmpty())
+			Expect(resource.Status.FailureTime).NotTo(BeNil())
+			Expect(resource.Status.FailureMessage).To(ContainSubstring("401"))
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseDenied))
+			Expect(resource.Status.CompletionTime).NotTo(BeNil())
+			Expect(resource.Status.LastHTTPStatusCode).To(Equal(http.StatusUnauthorized))
+		})
+		It("should fail the order when no returned certificate holds the requested key", func() {
+			estServer.issueForeignKey = true
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Certificate).To(BeEmpty())
+			Expect(resource.Status.FailureTime).NotTo(BeNil())
+			Expect(resource.Status.FailureMessage).To(Equal(errNoIssuedCertificate.Error()))
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseFailed))
+		})
+		It("should enroll with the bootstrap certificate of the issuer", func() {
+			By("Requiring TLS client authentication and configuring a bootstrap certificate")
+			estServer.requireClientCert = true
+			certPEM, keyPEM := estServer.issueClientCertificate("bootstrap.jquad.rocks", time.Now().Add(time.Hour))
+			clientCertSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estorder-bootstrap",
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM,
+					corev1.TLSPrivateKeyKey: keyPEM,
+				},
+			}
+			Expect(k8sClient.Create(ctx, clientCertSecret)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, clientCertSecret)).To(Succeed())
+			}()
+
+			issuer := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: issuerName, Namespace: "default"}, issuer)).To(Succeed())
+			issuer.Spec.AuthSecretName = ""
+			issuer.Spec.ClientCertSecretName = clientCertSecret.Name
+			Expect(k8sClient.Update(ctx, issuer)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseIssued))
+			Expect(resource.Status.Certificate).NotTo(BeEmpty())
+		})
+		It("should renew with the existing certificate via simplereenroll", func() {
+			certPEM, keyPEM := estServer.issueClientCertificate("test-est.jquad.rocks", time.Now().Add(time.Hour))
+			certificateSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estorder-certificate",
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM,
+					corev1.TLSPrivateKeyKey: keyPEM,
+				},
+			}
+			Expect(k8sClient.Create(ctx, certificateSecret)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, certificateSecret)).To(Succeed())
+			}()
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Renewal = true
+			resource.Spec.CertificateSecretName = certificateSecret.Name
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseIssued))
+			Expect(resource.Status.Operation).To(Equal(certmanagerv1.EstOrderOperationSimpleReenroll))
+			Expect(resource.Status.OperationMessage).To(BeEmpty())
+		})
+		It("should fall back to simpleenroll when the certificate being renewed has expired", func() {
+			certPEM, keyPEM := estServer.issueClientCertificate("test-est.jquad.rocks", time.Now().Add(-time.Hour))
+			certificateSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estorder-certificate",
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM,
+					corev1.TLSPrivateKeyKey: keyPEM,
+				},
+			}
+			Expect(k8sClient.Create(ctx, certificateSecret)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, certificateSecret)).To(Succeed())
+			}()
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.Renewal = true
+			resource.Spec.CertificateSecretName = certificateSecret.Name
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseIssued))
+			Expect(resource.Status.Operation).To(Equal(certmanagerv1.EstOrderOperationSimpleEnroll))
+			Expect(resource.Status.OperationMessage).To(ContainSubstring("expired"))
+		})
+		It("should write the key generated by the portal and the certificate to a secret", func() {
+			By("Letting the portal encrypt the generated key")
+			encryptionCert, certPEM, keyPEM := newKeyEncryptionCertificate()
+			estServer.keyEncryptionCert = encryptionCert
+			decryptionSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estorder-decryption",
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM,
+					corev1.TLSPrivateKeyKey: keyPEM,
+				},
+			}
+			Expect(k8sClient.Create(ctx, decryptionSecret)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, decryptionSecret)).To(Succeed())
+			}()
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.ServerKeyGen = &certmanagerv1.ServerKeyGen{
+				SecretName:           "test-estorder-generated",
+				DecryptionSecretName: decryptionSecret.Name,
+			}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseIssued))
+			Expect(resource.Status.Operation).To(Equal(certmanagerv1.EstOrderOperationServerKeyGen))
+
+			By("Checking the key pair in the secret")
+			generated := &corev1.Secret{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: "test-estorder-generated", Namespace: "default"}, generated)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, generated)).To(Succeed())
+			}()
+			Expect(generated.Type).To(Equal(corev1.SecretTypeTLS))
+			_, err = tls.X509KeyPair(generated.Data[corev1.TLSCertKey], generated.Data[corev1.TLSPrivateKeyKey])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(generated.OwnerReferences).To(HaveLen(1))
+			Expect(generated.OwnerReferences[0].Name).To(Equal(resourceName))
+		})
+		It("should enroll with a Full CMC request signed by the RA of the issuer", func() {
+			By("Configuring an RA certificate issued by the portal's CA")
+			certPEM, keyPEM := estServer.issueClientCertificate("ra.jquad.rocks", time.Now().Add(time.Hour))
+			raSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estorder-ra",
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM,
+					corev1.TLSPrivateKeyKey: keyPEM,
+				},
+			}
+			Expect(k8sClient.Create(ctx, raSecret)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, raSecret)).To(Succeed())
+			}()
+
+			issuer := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: issuerName, Namespace: "default"}, issuer)).To(Succeed())
+			issuer.Spec.FullCMC = &certmanagerv1.FullCMC{RASecretName: raSecret.Name}
+			Expect(k8sClient.Update(ctx, issuer)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseIssued))
+			Expect(resource.Status.Operation).To(Equal(certmanagerv1.EstOrderOperationFullCMC))
+			Expect(resource.Status.CMC).NotTo(BeNil())
+			Expect(resource.Status.CMC.Status).To(Equal("success"))
+			Expect(resource.Status.Certificate).NotTo(BeEmpty())
+		})
+		It("should deny the order when the CA rejects the Full CMC request", func() {
+			By("Signing the request with an RA certificate the portal does not trust")
+			_, certPEM, keyPEM := newKeyEncryptionCertificate()
+			raSecret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-estorder-ra",
+					Namespace: "default",
+				},
+				Type: corev1.SecretTypeTLS,
+				Data: map[string][]byte{
+					corev1.TLSCertKey:       certPEM,
+					corev1.TLSPrivateKeyKey: keyPEM,
+				},
+			}
+			Expect(k8sClient.Create(ctx, raSecret)).To(Succeed())
+			defer func() {
+				Expect(k8sClient.Delete(ctx, raSecret)).To(Succeed())
+			}()
+
+			issuer := &certmanagerv1.EstIssuer{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Name: issuerName, Namespace: "default"}, issuer)).To(Succeed())
+			issuer.Spec.FullCMC = &certmanagerv1.FullCMC{RASecretName: raSecret.Name}
+			Expect(k8sClient.Update(ctx, issuer)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseDenied))
+			Expect(resource.Status.CMC.FailInfo).To(Equal("badIdentity"))
+			Expect(resource.Status.FailureMessage).To(ContainSubstring("CMC status failed (badIdentity)"))
+		})
+		It("should fail the order when the issuer kind is not served by the operator", func() {
+			resource := &certmanagerv1.EstOrder{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.IssuerRef.Kind = "Issuer"
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{
+				Client: k8sClient,
+				Scheme: k8sClient.Scheme(),
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Phase).To(Equal(certmanagerv1.EstOrderPhaseFailed))
+			Expect(resource.Status.FailureMessage).To(ContainSubstring("issuer kind is not supported"))
+			Expect(resource.Status.Attempts).To(BeZero())
+		})
+	})
+
+	Context("When resolving an issuer reference", func() {
+		ctx := context.Background()
+
+		It("should reject issuers of other groups", func() {
+			_, err := getIssuerFromResource(ctx, k8sClient, certmanagerv1.IssuerRef{
+				Kind:  "EstIssuer",
+				Group: "cert-manager.io",
+				Name:  "test-resource",
+			}, "default")
+			Expect(err).To(MatchError(errIssuerGroupNotSupported))
+		})
+		It("should reject unknown kinds", func() {
+			_, err := getIssuerFromResource(ctx, k8sClient, certmanagerv1.IssuerRef{
+				Kind:  "Issuer",
+				Group: certmanagerv1.GroupVersion.Group,
+				Name:  "test-resource",
+			}, "default")
+			Expect(err).To(MatchError(errIssuerKindNotSupported))
+		})
+	})
+
+	Context("When interpreting the status of a CMC response", func() {
+		It("should name the failure reason", func() {
+			failInfo, err := asn1.Marshal(2)
+			Expect(err).NotTo(HaveOccurred())
+
+			response := &cmcResponse{}
+			response.setStatus(cmcStatusFailed)
+			Expect(response.setOtherStatusInfo(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagInteger, FullBytes: failInfo})).To(Succeed())
+			Expect(response.Status.FailInfo).To(Equal("badRequest"))
+			Expect(response.message()).To(Equal("CMC status failed (badRequest)"))
+		})
+
+		It("should name the OID of an extended failure reason", func() {
+			extendedFailInfo, err := asn1.Marshal(cmcExtendedFailInfo{
+				FailInfoOID:   asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1},
+				FailInfoValue: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagUTF8String, Bytes: []byte("key too weak")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			response := &cmcResponse{}
+			response.setStatus(cmcStatusFailed)
+			Expect(response.setOtherStatusInfo(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, FullBytes: extendedFailInfo})).To(Succeed())
+			Expect(response.Status.FailInfo).To(Equal("1.3.6.1.4.1.99999.1"))
+			Expect(response.Status.PendToken).To(BeEmpty())
+			Expect(response.message()).To(Equal("CMC status failed (1.3.6.1.4.1.99999.1)"))
+		})
+
+		It("should keep the token and time of a pending request", func() {
+			pendTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			pendInfo, err := asn1.Marshal(cmcPendInfo{PendToken: []byte("token"), PendTime: pendTime})
+			Expect(err).NotTo(HaveOccurred())
+
+			response := &cmcResponse{}
+			response.setStatus(cmcStatusPending)
+			Expect(response.setOtherStatusInfo(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, FullBytes: pendInfo})).To(Succeed())
+			Expect(response.Status.Status).To(Equal("pending"))
+			Expect(response.Status.PendToken).To(Equal([]byte("token")))
+			Expect(response.PendTime).To(BeTemporally("==", pendTime))
+		})
+	})
+
+	Context("When parsing the Retry-After header", func() {
+		now := time.Date(2024, time.December, 4, 13, 0, 0, 0, time.UTC)
+
+		It("should accept delta-seconds", func() {
+			wait, ok := parseRetryAfter("3600", now)
+			Expect(ok).To(BeTrue())
+			Expect(wait).To(Equal(time.Hour))
+		})
+		It("should accept an HTTP-date", func() {
+			wait, ok := parseRetryAfter("Wed, 04 Dec 2024 13:30:00 GMT", now)
+			Expect(ok).To(BeTrue())
+			Expect(wait).To(Equal(30 * time.Minute))
+		})
+		It("should not wait for an HTTP-date in the past", func() {
+			wait, ok := parseRetryAfter("Wed, 04 Dec 2024 12:00:00 GMT", now)
+			Expect(ok).To(BeTrue())
+			Expect(wait).To(BeZero())
+		})
+		It("should reject malformed values", func() {
+			_, ok := parseRetryAfter("soon", now)
+			Expect(ok).To(BeFalse())
+			_, ok = parseRetryAfter("-5", now)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("When retaining completed EstOrders", func() {
+		ctx := context.Background()
+
+		newCompletedEstOrder := func(name string, completed time.Time) *certmanagerv1.EstOrder {
+			estOrder := &certmanagerv1.EstOrder{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: "default",
+				},
+				Spec: certmanagerv1.EstOrderSpec{
+					IssuerRef: certmanagerv1.IssuerRef{
+						Kind:  certmanagerv1.EstIssuerKind,
+						Group: certmanagerv1.GroupVersion.Group,
+						Name:  "test-issuer",
+					},
+					Request: newTestCertificateRequest("test-est.jquad.rocks"),
+				},
+			}
+			Expect(k8sClient.Create(ctx, estOrder)).To(Succeed())
+			estOrder.Status.Phase = certmanagerv1.EstOrderPhaseFailed
+			estOrder.Status.FailureTime = &metav1.Time{Time: completed}
+			estOrder.Status.CompletionTime = &metav1.Time{Time: completed}
+			Expect(k8sClient.Status().Update(ctx, estOrder)).To(Succeed())
+			return estOrder
+		}
+
+		It("should keep completed EstOrders without retention", func() {
+			estOrder := newCompletedEstOrder("test-estorder-kept", time.Now().Add(-time.Hour))
+			defer func() {
+				Expect(k8sClient.Delete(ctx, estOrder)).To(Succeed())
+			}()
+
+			controllerReconciler := &EstOrderReconciler{Client: k8sClient, Scheme: k8sClient.Scheme()}
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(estOrder)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(estOrder), estOrder)).To(Succeed())
+		})
+		It("should delete completed EstOrders after the retention period", func() {
+			estOrder := newCompletedEstOrder("test-estorder-retained", time.Now())
+			controllerReconciler := &EstOrderReconciler{Client: k8sClient, Scheme: k8sClient.Scheme(), CompletedRetention: time.Hour}
+
+			By("Keeping the EstOrder within the retention period")
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(estOrder)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeNumerically("~", time.Hour, time.Minute))
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(estOrder), estOrder)).To(Succeed())
+
+			By("Deleting the EstOrder once the retention period has passed")
+			controllerReconciler.CompletedRetention = time.Nanosecond
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(estOrder)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(errors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(estOrder), estOrder))).To(BeTrue())
+		})
+		It("should keep completed EstOrders with server-side key generation", func() {
+			estOrder := newCompletedEstOrder("test-estorder-serverkeygen", time.Now().Add(-time.Hour))
+			defer func() {
+				Expect(k8sClient.Delete(ctx, estOrder)).To(Succeed())
+			}()
+			estOrder.Spec.ServerKeyGen = &certmanagerv1.ServerKeyGen{SecretName: "test-estorder-serverkeygen-tls"}
+			Expect(k8sClient.Update(ctx, estOrder)).To(Succeed())
+
+			controllerReconciler := &EstOrderReconciler{Client: k8sClient, Scheme: k8sClient.Scheme(), CompletedRetention: time.Nanosecond}
+			result, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(estOrder)})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(BeZero())
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(estOrder), estOrder)).To(Succeed())
+		})
+		It("should start the retention period when an EstOrder finishes", func() {
+			pending := &certmanagerv1.EstOrder{Status: certmanagerv1.EstOrderStatus{Phase: certmanagerv1.EstOrderPhaseAccepted}}
+			issued := pending.DeepCopy()
+			setEstOrderPhase(issued, certmanagerv1.EstOrderPhaseIssued, "issued")
+			Expect(issued.Status.CompletionTime).NotTo(BeNil())
+			Expect(estOrderBecameFinished().Update(event.UpdateEvent{ObjectOld: pending, ObjectNew: issued})).To(BeTrue())
+			Expect(estOrderBecameFinished().Update(event.UpdateEvent{ObjectOld: issued, ObjectNew: issued})).To(BeFalse())
+
+			denied := pending.DeepCopy()
+			setEstOrderPhase(denied, certmanagerv1.EstOrderPhaseDenied, "denied")
+			Expect(denied.Status.CompletionTime).NotTo(BeNil())
+			Expect(estOrderBecameFinished().Update(event.UpdateEvent{ObjectOld: pending, ObjectNew: denied})).To(BeTrue())
+		})
+	})
+
+	Context("When classifying the errors of the EST portal", func() {
+		newResponse := func(statusCode int, body string) *http.Response {
+			return &http.Response{
+				StatusCode: statusCode,
+				Status:     fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+				Header:     http.Header{},
+				Body:       io.NopCloser(strings.NewReader(body)),
+			}
+		}
+
+		It("should fail on errors caused by the request or the issuer", func() {
+			for statusCode, reason := range map[int]estErrorReason{
+				http.StatusBadRequest:         estErrorBadRequest,
+				http.StatusUnauthorized:       estErrorUnauthorized,
+				http.StatusForbidden:          estErrorUnauthorized,
+				http.StatusNotFound:           estErrorNotFound,
+				http.StatusConflict:           estErrorPolicyViolation,
+				http.StatusPreconditionFailed: estErrorPolicyViolation,
+				http.StatusGone:               estErrorRejected,
+			} {
+				estErr := newESTResponseError(newResponse(statusCode, "rejected by policy"), time.Now())
+				Expect(estErr.reason).To(Equal(reason), http.StatusText(statusCode))
+				Expect(estErr.retryable()).To(BeFalse(), http.StatusText(statusCode))
+				Expect(estErr.Error()).To(ContainSubstring("rejected by policy"))
+				Expect(estErr.denied()).To(Equal(reason == estErrorUnauthorized || reason == estErrorPolicyViolation), http.StatusText(statusCode))
+			}
+		})
+		It("should retry on errors of the portal", func() {
+			for statusCode, reason := range map[int]estErrorReason{
+				http.StatusRequestTimeout:      estErrorTimeout,
+				http.StatusTooManyRequests:     estErrorThrottled,
+				http.StatusServiceUnavailable:  estErrorThrottled,
+				http.StatusInternalServerError: estErrorServerError,
+				http.StatusBadGateway:          estErrorServerError,
+				http.StatusNoContent:           estErrorUnexpectedResponse,
+			} {
+				estErr := newESTResponseError(newResponse(statusCode, ""), time.Now())
+				Expect(estErr.reason).To(Equal(reason), http.StatusText(statusCode))
+				Expect(estErr.retryable()).To(BeTrue(), http.StatusText(statusCode))
+			}
+		})
+		It("should honor the Retry-After header of a throttled request", func() {
+			resp := newResponse(http.StatusTooManyRequests, "")
+			resp.Header.Set("Retry-After", "90")
+			Expect(newESTResponseError(resp, time.Now()).retryAfter).To(Equal(90 * time.Second))
+		})
+		It("should classify failed connections", func() {
+			Expect(newESTTransportError(fmt.Errorf("Post: %w", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}})).reason).To(Equal(estErrorTLSVerification))
+			Expect(newESTTransportError(fmt.Errorf("Post: %w", context.DeadlineExceeded)).reason).To(Equal(estErrorTimeout))
+			Expect(newESTTransportError(fmt.Errorf("connection refused")).reason).To(Equal(estErrorConnection))
+			Expect(newESTTransportError(fmt.Errorf("connection refused")).retryable()).To(BeTrue())
+		})
+		It("should double the backoff up to its maximum", func() {
+			Expect(estOrderBackoff(1)).To(Equal(initialEstOrderBackoff))
+			Expect(estOrderBackoff(2)).To(Equal(2 * initialEstOrderBackoff))
+			Expect(estOrderBackoff(100)).To(Equal(maxEstOrderBackoff))
 		})
 	})
 })